@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"pigeon-tool/pigeon"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time         time.Time `json:"time"`
+	User         string    `json:"user"`
+	Principal    string    `json:"principal"`
+	Environment  string    `json:"environment"`
+	Host         string    `json:"host"`
+	Subscription string    `json:"subscription"`
+	MessageID    string    `json:"messageId"`
+	Status       int       `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+// auditLog appends skip attempts to the audit log file.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+	base auditRecord
+}
+
+func defaultAuditLogPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "pigeon-tool", "audit.jsonl")
+}
+
+func auditLogFile() string {
+	if auditLogPath != "" {
+		return auditLogPath
+	}
+	return defaultAuditLogPath()
+}
+
+// certCommonName returns the CN of the PEM certificate at path.
+func certCommonName(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// openAuditLog opens the audit log for appending records about skips of
+// subscription.
+func openAuditLog(subscription string, reason string) (*auditLog, error) {
+	path := auditLogFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %s", err.Error())
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err.Error())
+	}
+
+	base := auditRecord{Environment: profileName, Subscription: subscription, Reason: reason}
+	if me, err := user.Current(); err == nil {
+		base.User = me.Username
+	}
+	if principal, err := certCommonName(certPath); err == nil {
+		base.Principal = principal
+	}
+	return &auditLog{file: file, base: base}, nil
+}
+
+// record appends the outcome of skipping id on host.
+func (a *auditLog) record(host string, id string, skipErr error) error {
+	rec := a.base
+	rec.Time = time.Now()
+	rec.Host = host
+	rec.MessageID = id
+	switch e := skipErr.(type) {
+	case nil:
+		rec.Status = 200
+	case *pigeon.StatusError:
+		rec.Status = e.Code
+		rec.Error = e.Error()
+	default:
+		rec.Error = e.Error()
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.file.Write(append(line, '\n'))
+	return err
+}
+
+func (a *auditLog) Close() error {
+	return a.file.Close()
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var authOutput string
+
+// certStatus describes a certificate file.
+type certStatus struct {
+	Path      string     `json:"path"`
+	Principal string     `json:"principal,omitempty"`
+	Serial    string     `json:"serial,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+	Valid     bool       `json:"valid"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// authStatus is the output of auth status.
+type authStatus struct {
+	Profile string `json:"profile"`
+	// ProfileError says why the profile could not be resolved.
+	ProfileError string `json:"profileError,omitempty"`
+	Source       string `json:"source"`
+	// SourceError says why no key/cert pair was found at Source.
+	SourceError string     `json:"sourceError,omitempty"`
+	KeyPath     string     `json:"keyPath"`
+	UserCert    certStatus `json:"userCert"`
+	RoleDomain  string     `json:"roleDomain"`
+	RoleName    string     `json:"roleName"`
+	RoleCert    certStatus `json:"roleCert"`
+}
+
+// detectIdentitySource finds the key/cert pair the same way authentication
+// does, without running athenz-user-cert, and says where it came from. The
+// error says why no pair was found there.
+func detectIdentitySource() (string, error) {
+	if keyPath != "" && certPath != "" {
+		return "command line", nil
+	}
+
+	me, err := user.Current()
+	if err != nil {
+		return "unknown", err
+	}
+	if me.Username == "root" {
+		return "SIA", detectSIAKeyCertPair()
+	}
+	keyPath, certPath = athenzUserKeyCertPaths(me)
+	return "athenz-user-cert", nil
+}
+
+func readCertStatus(path string, validate func() error) certStatus {
+	status := certStatus{Path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		status.Error = "no PEM certificate found"
+		return status
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Principal = cert.Subject.CommonName
+	status.Serial = cert.SerialNumber.String()
+	status.NotAfter = &cert.NotAfter
+
+	if err = validate(); err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Valid = true
+	return status
+}
+
+func printCertStatus(w *tabwriter.Writer, name string, status certStatus) {
+	fmt.Fprintf(w, "%s:\t%s\n", name, status.Path)
+	if status.NotAfter != nil {
+		fmt.Fprintf(w, "  principal:\t%s\n", status.Principal)
+		fmt.Fprintf(w, "  serial:\t%s\n", status.Serial)
+		fmt.Fprintf(w, "  expires:\t%s (%s)\n", status.NotAfter.Local().Format(time.RFC3339), time.Until(*status.NotAfter).Round(time.Minute))
+	}
+	if status.Valid {
+		fmt.Fprintf(w, "  valid:\tyes\n")
+	} else {
+		fmt.Fprintf(w, "  valid:\tno, %s\n", status.Error)
+	}
+}
+
+func runAuthStatus(cmd *cobra.Command, args []string) error {
+	cmd.SilenceUsage = true
+	var status authStatus
+	if err := resolveProfile(cmd); err != nil {
+		status.ProfileError = err.Error()
+	}
+	status.Profile = profileName
+	status.RoleDomain = profile.AthenzDomain
+	status.RoleName = profile.RoleName
+
+	source, err := detectIdentitySource()
+	status.Source = source
+	if err != nil {
+		status.SourceError = err.Error()
+		status.UserCert = certStatus{Error: "no identity found"}
+	} else {
+		status.KeyPath = keyPath
+		status.UserCert = readCertStatus(certPath, func() error {
+			return validateKeyCertPair(keyPath, certPath)
+		})
+	}
+
+	if status.ProfileError != "" {
+		status.RoleCert = certStatus{Error: "no profile"}
+	} else {
+		rolePath, err := roleCertFile(profileName, profile)
+		if err != nil {
+			return err
+		}
+		status.RoleCert = readCertStatus(rolePath, func() error {
+			if status.SourceError != "" {
+				return fmt.Errorf("no identity key to check it against")
+			}
+			return validateRoleCert(keyPath, rolePath, profile)
+		})
+	}
+
+	switch authOutput {
+	case "json":
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		return printJSON(data)
+	case "", "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 1, ' ', 0)
+		if status.ProfileError != "" {
+			fmt.Fprintf(w, "profile:\t%s (invalid: %s)\n", status.Profile, status.ProfileError)
+		} else {
+			fmt.Fprintf(w, "profile:\t%s\n", status.Profile)
+		}
+		if status.SourceError != "" {
+			fmt.Fprintf(w, "identity source:\t%s (unavailable: %s)\n", status.Source, status.SourceError)
+		} else {
+			fmt.Fprintf(w, "identity source:\t%s\n", status.Source)
+		}
+		fmt.Fprintf(w, "key:\t%s\n", status.KeyPath)
+		printCertStatus(w, "user cert", status.UserCert)
+		if status.RoleDomain != "" {
+			fmt.Fprintf(w, "role:\t%s:role.%s\n", status.RoleDomain, status.RoleName)
+		}
+		printCertStatus(w, "role cert", status.RoleCert)
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown output format %q, expecting text or json", authOutput)
+}
+
+// authCmd groups the Athenz authentication commands.
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Athenz authentication commands",
+}
+
+// authStatusCmd represents the auth status command
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "show the Athenz identity and role certificate in use",
+	Long: `
+Eg. pigeon-tool auth status
+Eg. pigeon-tool -i auth status -o json
+	`,
+	Annotations: map[string]string{"authenticate": authNone},
+	RunE:        runAuthStatus,
+}
+
+// whoamiCmd is a shortcut for auth status.
+var whoamiCmd = &cobra.Command{
+	Use:         "whoami",
+	Short:       "same as auth status",
+	Annotations: map[string]string{"authenticate": authNone},
+	RunE:        runAuthStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authStatusCmd)
+	rootCmd.AddCommand(whoamiCmd)
+
+	for _, c := range []*cobra.Command{authStatusCmd, whoamiCmd} {
+		c.Flags().StringVarP(&authOutput, "output", "o", "text", "output format: text or json")
+	}
+}
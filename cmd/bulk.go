@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"pigeon-tool/pigeon"
+)
+
+// skipOutcome is the result of skipping one target.
+type skipOutcome struct {
+	Target    skipTarget
+	Attempted bool
+	Err       error
+}
+
+// bulkOptions controls how runBulkSkip sends its requests.
+type bulkOptions struct {
+	// FailFast stops starting new requests after the first failure.
+	FailFast bool
+	// Concurrency is the number of requests in flight at once.
+	Concurrency int
+	// Rate is the maximum number of requests per second to each host, or
+	// zero for no limit.
+	Rate float64
+	// Progress, if not nil, is advanced as requests finish.
+	Progress *progressBar
+	// Checkpoint, if not nil, records every skipped message.
+	Checkpoint *checkpoint
+	// Interrupt stops starting new requests when it receives.
+	Interrupt <-chan os.Signal
+}
+
+// rateLimiter spaces out the requests to each host.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rate), next: make(map[string]time.Time)}
+}
+
+// wait blocks until a request to host is allowed.
+func (l *rateLimiter) wait(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runBulkSkip skips targets with a pool of workers and returns one outcome
+// per target, in the order of targets. With FailFast, no new request is
+// started after the first failure; requests already in flight are allowed to
+// finish.
+func runBulkSkip(ctx context.Context, client *pigeon.Client, audit *auditLog, subscription pigeon.SubscriptionName, targets []skipTarget, opts bulkOptions) []skipOutcome {
+	outcomes := make([]skipOutcome, len(targets))
+	for i, t := range targets {
+		outcomes[i].Target = t
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	limiter := newRateLimiter(opts.Rate)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+
+	jobs := make(chan *skipOutcome)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for outcome := range jobs {
+				if limiter.wait(ctx, outcome.Target.Host) != nil {
+					continue
+				}
+				outcome.Attempted = true
+				outcome.Err = skipMessage(ctx, client, audit, subscription, outcome.Target)
+				if outcome.Err == nil {
+					if err := opts.Checkpoint.markDone(outcome.Target); err != nil {
+						fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+					}
+				}
+				if outcome.Err != nil && opts.FailFast {
+					stopOnce.Do(func() { close(stop) })
+				}
+				opts.Progress.increment()
+			}
+		}()
+	}
+
+feed:
+	for i := range outcomes {
+		select {
+		case jobs <- &outcomes[i]:
+		case <-stop:
+			break feed
+		case <-opts.Interrupt:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	opts.Progress.finish()
+
+	return outcomes
+}
+
+// printSkipReport prints which targets were skipped, failed or not attempted,
+// and returns an error unless all were skipped.
+func printSkipReport(w io.Writer, outcomes []skipOutcome) error {
+	var succeeded, failed, notAttempted []skipOutcome
+	for _, outcome := range outcomes {
+		switch {
+		case !outcome.Attempted:
+			notAttempted = append(notAttempted, outcome)
+		case outcome.Err != nil:
+			failed = append(failed, outcome)
+		default:
+			succeeded = append(succeeded, outcome)
+		}
+	}
+
+	if len(succeeded) != 0 {
+		fmt.Fprintf(w, "Succeeded:\n")
+		for _, outcome := range succeeded {
+			fmt.Fprintf(w, "  %s %s\n", outcome.Target.Host, outcome.Target.ID)
+		}
+	}
+	if len(failed) != 0 {
+		fmt.Fprintf(w, "Failed:\n")
+		for _, outcome := range failed {
+			fmt.Fprintf(w, "  %s %s: %s\n", outcome.Target.Host, outcome.Target.ID, outcome.Err.Error())
+		}
+	}
+	if len(notAttempted) != 0 {
+		fmt.Fprintf(w, "Not attempted:\n")
+		for _, outcome := range notAttempted {
+			fmt.Fprintf(w, "  %s %s\n", outcome.Target.Host, outcome.Target.ID)
+		}
+	}
+	fmt.Fprintf(w, "%d succeeded, %d failed, %d not attempted\n", len(succeeded), len(failed), len(notAttempted))
+
+	if len(failed) != 0 || len(notAttempted) != 0 {
+		return fmt.Errorf("%d of %d messages were not skipped", len(failed)+len(notAttempted), len(outcomes))
+	}
+	return nil
+}
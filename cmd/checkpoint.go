@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkpointEntry is one line of a checkpoint file. The first line is the
+// plan; every following line records a skipped message.
+type checkpointEntry struct {
+	Type         string       `json:"type"`
+	Created      *time.Time   `json:"created,omitempty"`
+	Profile      string       `json:"profile,omitempty"`
+	Subscription string       `json:"subscription,omitempty"`
+	Targets      []skipTarget `json:"targets,omitempty"`
+	Host         string       `json:"host,omitempty"`
+	ID           string       `json:"id,omitempty"`
+}
+
+// checkpoint records the progress of a bulk skip so it can be resumed.
+type checkpoint struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	plan checkpointEntry
+	done map[skipTarget]bool
+}
+
+// defaultCheckpointPath returns a new checkpoint file name in the cache
+// directory.
+func defaultCheckpointPath(subscription string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, "checkpoints")
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create checkpoint directory: %s", err.Error())
+	}
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(subscription)
+	return filepath.Join(dir, time.Now().Format("20060102-150405")+"-"+name+".jsonl"), nil
+}
+
+// createCheckpoint starts a checkpoint file for skipping targets.
+func createCheckpoint(path string, profile string, subscription string, targets []skipTarget) (*checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint file: %s", err.Error())
+	}
+
+	now := time.Now()
+	cp := &checkpoint{
+		path: path,
+		file: file,
+		plan: checkpointEntry{Type: "plan", Created: &now, Profile: profile, Subscription: subscription, Targets: targets},
+		done: make(map[skipTarget]bool),
+	}
+	if err = cp.write(cp.plan); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return cp, nil
+}
+
+// openCheckpoint reads a checkpoint file and opens it to record more
+// progress.
+func openCheckpoint(path string) (*checkpoint, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint file: %s", err.Error())
+	}
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read checkpoint file: %s", err.Error())
+	}
+	// Drop a last line cut short by a crash, so that new entries start on a
+	// line of their own.
+	if complete := bytes.LastIndexByte(data, '\n') + 1; complete != len(data) {
+		if err = file.Truncate(int64(complete)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to repair checkpoint file: %s", err.Error())
+		}
+		data = data[:complete]
+	}
+
+	cp := &checkpoint{path: path, file: file, done: make(map[skipTarget]bool)}
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		var entry checkpointEntry
+		if err = json.Unmarshal(line, &entry); err != nil && i != 0 {
+			// A line garbled by an earlier crash.
+			continue
+		}
+		switch {
+		case i == 0 && entry.Type == "plan":
+			cp.plan = entry
+		case i == 0:
+			file.Close()
+			return nil, fmt.Errorf("%s is not a checkpoint file", path)
+		case entry.Type == "done":
+			cp.done[skipTarget{Host: entry.Host, ID: entry.ID}] = true
+		}
+	}
+	if cp.plan.Type != "plan" {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a checkpoint file", path)
+	}
+	return cp, nil
+}
+
+// remaining returns the planned targets not yet skipped.
+func (cp *checkpoint) remaining() []skipTarget {
+	var targets []skipTarget
+	for _, t := range cp.plan.Targets {
+		if !cp.done[t] {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// markDone records that t was skipped.
+func (cp *checkpoint) markDone(t skipTarget) error {
+	if cp == nil {
+		return nil
+	}
+	return cp.write(checkpointEntry{Type: "done", Host: t.Host, ID: t.ID})
+}
+
+func (cp *checkpoint) write(entry checkpointEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if _, err = cp.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %s", err.Error())
+	}
+	return nil
+}
+
+func (cp *checkpoint) Close() error {
+	return cp.file.Close()
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpenCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const plan = `{"type":"plan","profile":"prod","subscription":"CQI.prod.a.b::CQO.prod.a.b.c",` +
+		`"targets":[{"host":"h1","id":"m1"},{"host":"h1","id":"m2"},{"host":"h2","id":"m1"}]}` + "\n"
+	const done = `{"type":"done","host":"h1","id":"m1"}` + "\n"
+	tests := []struct {
+		name      string
+		data      string
+		wantErr   bool
+		remaining []skipTarget
+		// repaired is the file after opening.
+		repaired string
+	}{
+		{
+			name:      "plan only",
+			data:      plan,
+			remaining: []skipTarget{{"h1", "m1"}, {"h1", "m2"}, {"h2", "m1"}},
+			repaired:  plan,
+		},
+		{
+			name:      "done entry",
+			data:      plan + done,
+			remaining: []skipTarget{{"h1", "m2"}, {"h2", "m1"}},
+			repaired:  plan + done,
+		},
+		{
+			name:      "truncated last line",
+			data:      plan + done + `{"type":"done","ho`,
+			remaining: []skipTarget{{"h1", "m2"}, {"h2", "m1"}},
+			repaired:  plan + done,
+		},
+		{
+			name:      "garbled line",
+			data:      plan + `{"type":"do` + "\n" + `{"type":"done","host":"h2","id":"m1"}` + "\n",
+			remaining: []skipTarget{{"h1", "m1"}, {"h1", "m2"}},
+			repaired:  plan + `{"type":"do` + "\n" + `{"type":"done","host":"h2","id":"m1"}` + "\n",
+		},
+		{
+			name:    "no plan",
+			data:    done,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			data:    "m1\nm2\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			data:    "",
+			wantErr: true,
+		},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, string('a'+rune(i))+".jsonl")
+		if err = ioutil.WriteFile(path, []byte(tt.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		cp, err := openCheckpoint(path)
+		if tt.wantErr {
+			if err == nil {
+				cp.Close()
+				t.Errorf("%s: openCheckpoint() succeeded, want error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: openCheckpoint() = %s", tt.name, err.Error())
+			continue
+		}
+		if got := cp.remaining(); !reflect.DeepEqual(got, tt.remaining) {
+			t.Errorf("%s: remaining() = %v, want %v", tt.name, got, tt.remaining)
+		}
+
+		// A new entry starts on a line of its own.
+		if err = cp.markDone(skipTarget{"h1", "m2"}); err != nil {
+			t.Errorf("%s: markDone() = %s", tt.name, err.Error())
+		}
+		cp.Close()
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tt.repaired + `{"type":"done","host":"h1","id":"m2"}` + "\n"
+		if string(data) != want {
+			t.Errorf("%s: file = %q, want %q", tt.name, data, want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// snapshotMaxAge is how old the cached status may get before completion
+// refreshes it in the background.
+const snapshotMaxAge = 5 * time.Minute
+
+// bashCompletionFixes adapts the bash script of cobra v1.0.0 to values
+// containing colons, as subscription names do: words are not split at
+// colons.
+var bashCompletionFixes = []struct{ old, new string }{
+	{"_init_completion -s || return", "_init_completion -s -n : || return"},
+	{`_init_completion -n "=" || return`, `_init_completion -n "=:" || return`},
+	{`done < <(compgen -W "${out[*]}" -- "$cur")`, `done < <(compgen -W "${out[*]}" -- "$cur")
+        if declare -F __ltrim_colon_completions >/dev/null; then
+            __ltrim_colon_completions "$cur"
+        fi`},
+}
+
+// genBashCompletion writes the bash completion script to w.
+func genBashCompletion(w io.Writer) error {
+	var buf bytes.Buffer
+	if err := rootCmd.GenBashCompletion(&buf); err != nil {
+		return err
+	}
+	script := buf.String()
+	for _, fix := range bashCompletionFixes {
+		// A cobra upgrade may change the script; fail rather than ship a
+		// script that breaks on subscription names.
+		if !strings.Contains(script, fix.old) {
+			return fmt.Errorf("bash completion script has no %q to patch", fix.old)
+		}
+		script = strings.Replace(script, fix.old, fix.new, 1)
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+// zshCompletion asks pigeon-tool for completions, like the bash script. The
+// zsh script of cobra v1.0.0 only completes commands and flags.
+const zshCompletion = `#compdef pigeon-tool
+
+_pigeon-tool() {
+    local out directive
+    local -a lines values
+
+    # The words up to the cursor, the last one possibly empty.
+    out=$("${words[1]}" __completeNoDesc "${(@)words[2,CURRENT]}" 2>/dev/null)
+    lines=("${(@f)out}")
+    directive=${lines[-1]#:}
+    values=("${(@)lines[1,-2]}")
+
+    # Error
+    if (( directive & 1 )); then
+        return 1
+    fi
+    if (( ${#values} == 0 )); then
+        # NoFileComp
+        (( directive & 4 )) || _files
+        return
+    fi
+    # NoSpace
+    if (( directive & 2 )); then
+        compadd -S '' -- "${values[@]}"
+    else
+        compadd -- "${values[@]}"
+    fi
+}
+
+if [ "$funcstack[1]" = "_pigeon-tool" ]; then
+    _pigeon-tool "$@"
+else
+    compdef _pigeon-tool pigeon-tool
+fi
+`
+
+// completionSnapshot returns the cached status of the profile selected on
+// the command line being completed, starting a background refresh if it is
+// missing or stale.
+func completionSnapshot(cmd *cobra.Command) (*statusSnapshot, error) {
+	if err := resolveProfile(cmd); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := loadStatusSnapshot()
+	if err != nil || time.Since(snapshot.Time) > snapshotMaxAge {
+		if err := refreshSnapshotInBackground(cmd); err != nil {
+			log.Printf("failed to refresh cached status: %s", err.Error())
+		}
+	}
+	return snapshot, err
+}
+
+// refreshSnapshotInBackground starts refresh-cache with the global flags
+// of cmd, unless it was started less than snapshotMaxAge ago or would have
+// to renew credentials. Completion must not run athenz-user-cert, which may
+// prompt, or fetch a role certificate from ZTS on a key press.
+func refreshSnapshotInBackground(cmd *cobra.Command) error {
+	if err := validCachedCredentials(); err != nil {
+		return fmt.Errorf("not refreshing without valid cached credentials: %s", err.Error())
+	}
+
+	path, err := snapshotFile(profileName)
+	if err != nil {
+		return err
+	}
+	marker := path + ".refresh"
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < snapshotMaxAge {
+		return nil
+	}
+	if err = writeFileAtomic(marker, nil, 0600); err != nil {
+		return err
+	}
+
+	args := []string{"refresh-cache"}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if rootCmd.PersistentFlags().Lookup(f.Name) == nil {
+			return
+		}
+		value := f.Value.String()
+		if f.Name == "hosts" {
+			value = strings.Join(staticHosts, ",")
+		}
+		args = append(args, "--"+f.Name+"="+value)
+	})
+	refresh := exec.Command(os.Args[0], args...)
+	if err = refresh.Start(); err != nil {
+		return err
+	}
+	return refresh.Process.Release()
+}
+
+// validCachedCredentials checks the user and role certificates authenticate
+// would use, without renewing them.
+func validCachedCredentials() error {
+	key, cert := keyPath, certPath
+	if key == "" || cert == "" {
+		me, err := user.Current()
+		if err != nil {
+			return err
+		}
+		if me.Username == "root" {
+			if err = detectSIAKeyCertPair(); err != nil {
+				return err
+			}
+			key, cert = keyPath, certPath
+		} else {
+			key, cert = athenzUserKeyCertPaths(me)
+		}
+	}
+	if err := validateKeyCertPair(key, cert); err != nil {
+		return err
+	}
+
+	path, err := roleCertFile(profileName, profile)
+	if err != nil {
+		return err
+	}
+	return validateRoleCert(key, path, profile)
+}
+
+// completeNamespaces completes -n from the cached status.
+func completeNamespaces(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	snapshot, err := completionSnapshot(cmd)
+	if err != nil {
+		return []string{"all"}, cobra.ShellCompDirectiveNoFileComp
+	}
+	names := []string{"all"}
+	for _, s := range summarizeNamespaces(snapshot.results()) {
+		names = append(names, fmt.Sprintf("%s\t%d subscriptions, %d stuck", s.Name, s.Subscriptions, s.Stuck))
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeSubscriptions completes -q with the subscriptions that have old
+// messages in the cached status.
+func completeSubscriptions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	snapshot, err := completionSnapshot(cmd)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	stuck := make(map[string]int)
+	namespaces := make(map[string]string)
+	for _, row := range collectRows(snapshot.results(), "all") {
+		stuck[row.Subscription] += row.Count
+		namespaces[row.Subscription] = row.Namespace
+	}
+	var names []string
+	for name, count := range stuck {
+		names = append(names, fmt.Sprintf("%s\t%s, %d stuck", name, namespaces[name], count))
+	}
+	sort.Strings(names)
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeMessageIDs completes -m with the old messages of the -q
+// subscription in the cached status.
+func completeMessageIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	ids := []string{"all\tevery old message", "-\tread IDs from stdin"}
+	if queue == "" {
+		return ids, cobra.ShellCompDirectiveNoFileComp
+	}
+	snapshot, err := completionSnapshot(cmd)
+	if err != nil {
+		return ids, cobra.ShellCompDirectiveNoFileComp
+	}
+	seen := make(map[string]bool)
+	for _, t := range oldMessageTargets(snapshot.results(), queue) {
+		if !seen[t.ID] {
+			seen[t.ID] = true
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids, cobra.ShellCompDirectiveNoFileComp
+}
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "generate shell completion scripts",
+	Long: `
+Namespaces, subscriptions and message IDs are completed from the status cached
+by list and ns-list, which is refreshed in the background when it is older
+than 5 minutes and the cached user and role certificates are still valid.
+Completion never renews certificates.
+
+Eg. source <(pigeon-tool completion bash)
+Eg. pigeon-tool completion zsh > "${fpath[1]}/_pigeon-tool"
+Eg. pigeon-tool completion fish > ~/.config/fish/completions/pigeon-tool.fish
+	`,
+	Args:        cobra.ExactValidArgs(1),
+	ValidArgs:   []string{"bash", "zsh", "fish"},
+	Annotations: map[string]string{"authenticate": authNone},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return genBashCompletion(os.Stdout)
+		case "zsh":
+			_, err := io.WriteString(os.Stdout, zshCompletion)
+			return err
+		default:
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+	},
+}
+
+// refreshCacheCmd is started by completion to refresh the cached status.
+var refreshCacheCmd = &cobra.Command{
+	Use:    "refresh-cache",
+	Short:  "refresh the cached status used by completion",
+	Hidden: true,
+	// Loads the profile and authenticates in liveStatus.
+	Annotations: map[string]string{"authenticate": authNone},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		results, err := liveStatus(context.Background(), cmd)
+		if err != nil {
+			return err
+		}
+		return saveStatusSnapshot(results)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(refreshCacheCmd)
+}
@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenBashCompletion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := genBashCompletion(&buf); err != nil {
+		t.Fatalf("genBashCompletion() = %s", err.Error())
+	}
+	script := buf.String()
+	// The directive is the text after the last colon of the output.
+	if !strings.Contains(script, "out=${out%:*}") {
+		t.Errorf("script does not strip the directive at the last colon")
+	}
+	for _, fix := range bashCompletionFixes {
+		if !strings.Contains(script, fix.new) {
+			t.Errorf("script does not contain %q", fix.new)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"pigeon-tool/pigeon"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Profile describes one Pigeon cluster and the Athenz role used to manage it.
+type Profile struct {
+	// Discovery selects the host discovery backend: role, static, dns or
+	// dns-srv. When empty it is inferred from the fields below.
+	Discovery    string   `yaml:"discovery"`
+	HostEndpoint string   `yaml:"host_endpoint"`
+	Hosts        []string `yaml:"hosts"`
+	HostsFile    string   `yaml:"hosts_file"`
+	DNSName      string   `yaml:"dns_name"`
+	// Environment is the <env> segment of the subscription names in the
+	// cluster, e.g. CQI.prod...::CQO.prod... When empty, names are not
+	// checked against it.
+	Environment string `yaml:"environment"`
+
+	AthenzDomain string `yaml:"athenz_domain"`
+	RoleName     string `yaml:"role_name"`
+	ZTSURL       string `yaml:"zts_url"`
+	DNSDomain    string `yaml:"dns_domain"`
+	// CABundle is a PEM file of CAs trusted in addition to the system
+	// pool, typically the Athenz CA bundle.
+	CABundle string `yaml:"ca_bundle"`
+
+	StatusURL string `yaml:"status_url"`
+	SkipURL   string `yaml:"skip_url"`
+	Port      int    `yaml:"port"`
+}
+
+// Config is the content of the config file.
+type Config struct {
+	DefaultProfile string              `yaml:"default_profile"`
+	Profiles       map[string]*Profile `yaml:"profiles"`
+}
+
+// builtinProfiles are the clusters known without a config file. Profiles in
+// the config file with the same name override them field by field.
+var builtinProfiles = map[string]Profile{
+	"prod": {
+		HostEndpoint: "https://edge.dist.yahoo.com:4443/roles/v1/roles/nevec_egs_pigeon.HOSTs.prod/members?output=json",
+		AthenzDomain: "nevec.pigeon.prod",
+		Environment:  "prod",
+	},
+	"int": {
+		HostEndpoint: "https://edge.dist.yahoo.com:4443/roles/v1/roles/nevec_egs_pigeon.HOSTs.int/members?output=json",
+		AthenzDomain: "nevec.pigeon.int",
+		Environment:  "int",
+	},
+}
+
+// profileDefaults fills the fields every profile shares.
+var profileDefaults = Profile{
+	RoleName:  "pigeon_admin_role",
+	ZTSURL:    "https://zts.athens.yahoo.com:4443/zts/v1",
+	DNSDomain: "zts.yahoo.cloud",
+	StatusURL: pigeon.DefaultStatusURL,
+	SkipURL:   pigeon.DefaultSkipURL,
+	Port:      pigeon.DefaultPort,
+}
+
+// merge copies the non-zero fields of o into p.
+func (p *Profile) merge(o Profile) {
+	if o.Discovery != "" {
+		p.Discovery = o.Discovery
+	}
+	if o.HostEndpoint != "" {
+		p.HostEndpoint = o.HostEndpoint
+	}
+	if len(o.Hosts) != 0 {
+		p.Hosts = o.Hosts
+	}
+	if o.HostsFile != "" {
+		p.HostsFile = o.HostsFile
+	}
+	if o.DNSName != "" {
+		p.DNSName = o.DNSName
+	}
+	if o.Environment != "" {
+		p.Environment = o.Environment
+	}
+	if o.AthenzDomain != "" {
+		p.AthenzDomain = o.AthenzDomain
+	}
+	if o.RoleName != "" {
+		p.RoleName = o.RoleName
+	}
+	if o.ZTSURL != "" {
+		p.ZTSURL = o.ZTSURL
+	}
+	if o.DNSDomain != "" {
+		p.DNSDomain = o.DNSDomain
+	}
+	if o.CABundle != "" {
+		p.CABundle = o.CABundle
+	}
+	if o.StatusURL != "" {
+		p.StatusURL = o.StatusURL
+	}
+	if o.SkipURL != "" {
+		p.SkipURL = o.SkipURL
+	}
+	if o.Port != 0 {
+		p.Port = o.Port
+	}
+}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "pigeon-tool", "config.yaml")
+}
+
+// loadConfig reads the config file. A missing file is only an error when
+// the path was given explicitly.
+func loadConfig(path string, explicit bool) (*Config, error) {
+	var config Config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			log.Printf("no config file at %s, using built-in profiles", path)
+			return &config, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %s", err.Error())
+	}
+	log.Printf("read config file: %s", path)
+
+	if err = yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %s", path, err.Error())
+	}
+	return &config, nil
+}
+
+// resolveProfile selects the profile named by --profile, -i or the config
+// file, and applies the command-line overrides on top of it.
+func resolveProfile(cmd *cobra.Command) error {
+	path := configPath
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	config, err := loadConfig(path, configPath != "")
+	if err != nil {
+		return err
+	}
+
+	name := profileName
+	if staging {
+		if name != "" && name != "int" {
+			return fmt.Errorf("-i selects the int profile and conflicts with --profile %s", name)
+		}
+		name = "int"
+	}
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	if name == "" {
+		name = "prod"
+	}
+
+	builtin, isBuiltin := builtinProfiles[name]
+	custom, isCustom := config.Profiles[name]
+	if !isBuiltin && !isCustom {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	p := profileDefaults
+	p.merge(builtin)
+	if isCustom && custom != nil {
+		p.merge(*custom)
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("role") {
+		p.RoleName = roleName
+	}
+	if flags.Changed("discovery") {
+		p.Discovery = discovery
+	}
+	if flags.Changed("hosts") {
+		p.Hosts = staticHosts
+	}
+	if flags.Changed("hosts-file") {
+		p.HostsFile = hostsFile
+	}
+	if flags.Changed("dns-name") {
+		p.DNSName = dnsName
+	}
+	if flags.Changed("ca-bundle") {
+		p.CABundle = caBundle
+	}
+
+	if p.AthenzDomain == "" {
+		return fmt.Errorf("profile %s has no athenz_domain", name)
+	}
+
+	profileName = name
+	profile = p
+	log.Printf("using profile %s: %+v", profileName, profile)
+	return nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// previewOldest is how many of the oldest message IDs the skip summary
+// shows per host.
+const previewOldest = 3
+
+// printSkipSummary prints how many messages of subscription would be skipped
+// on each host, with the oldest IDs.
+func printSkipSummary(w io.Writer, subscription string, targets []skipTarget) {
+	var hosts []string
+	byHost := make(map[string][]string)
+	for _, t := range targets {
+		if _, ok := byHost[t.Host]; !ok {
+			hosts = append(hosts, t.Host)
+		}
+		byHost[t.Host] = append(byHost[t.Host], t.ID)
+	}
+
+	fmt.Fprintf(w, "About to skip old messages of %s:\n", subscription)
+	for _, host := range hosts {
+		ids := byHost[host]
+		fmt.Fprintf(w, "  %s: %d messages\n", host, len(ids))
+		for i, id := range ids {
+			if i == previewOldest {
+				fmt.Fprintf(w, "    ...\n")
+				break
+			}
+			fmt.Fprintf(w, "    %s\n", id)
+		}
+	}
+	fmt.Fprintf(w, "Total: %d messages on %d hosts\n", len(targets), len(hosts))
+}
+
+// confirmSkip asks the operator to type the subscription name. It refuses
+// when stdin is not a terminal, since nobody can answer. If stdin was used
+// for input, the answer is read from the controlling terminal instead.
+func confirmSkip(subscription string, stdinUsed bool) error {
+	in := os.Stdin
+	if stdinUsed {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			return fmt.Errorf("refusing to skip without confirmation, no terminal to ask on; use --yes to skip anyway")
+		}
+		defer tty.Close()
+		in = tty
+	}
+	if !isTerminal(in) {
+		return fmt.Errorf("refusing to skip without confirmation, stdin is not a terminal; use --yes to skip anyway")
+	}
+
+	fmt.Fprintf(os.Stderr, "Type the subscription name to confirm: ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read confirmation: %s", err.Error())
+	}
+	if strings.TrimSpace(answer) != subscription {
+		return fmt.Errorf("confirmation did not match, nothing skipped")
+	}
+	return nil
+}
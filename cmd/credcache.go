@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheDir returns the per-user cache directory of pigeon-tool, creating it
+// if needed. Only the owner may access it.
+func cacheDir() (string, error) {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".cache")
+	}
+	dir = filepath.Join(dir, "pigeon-tool")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %s", err.Error())
+	}
+	// Tighten a directory created by someone else or an older version.
+	if err := os.Chmod(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to restrict cache directory: %s", err.Error())
+	}
+	return dir, nil
+}
+
+// roleCertFile returns where the role certificate of a profile is cached.
+func roleCertFile(name string, p Profile) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	key := strings.Join([]string{name, p.AthenzDomain, p.RoleName}, "_")
+	key = strings.Replace(key, string(filepath.Separator), "-", -1)
+	return filepath.Join(dir, key+".cert"), nil
+}
+
+// writeFileAtomic writes data to path through a temporary file in the same
+// directory, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
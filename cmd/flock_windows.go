@@ -0,0 +1,6 @@
+package cmd
+
+// lockFile is a no-op on Windows, where concurrent invocations may race.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var historySince time.Duration
+var historySubscription string
+var historyHost string
+var historyUser string
+var historyMessage string
+var historyEnvironment string
+var historyFailed bool
+var historyOutput string
+
+// matches reports whether rec passes the history filters.
+func (rec *auditRecord) matches(now time.Time) bool {
+	if historySince != 0 && rec.Time.Before(now.Add(-historySince)) {
+		return false
+	}
+	if historySubscription != "" && !strings.Contains(rec.Subscription, historySubscription) {
+		return false
+	}
+	if historyHost != "" && rec.Host != historyHost {
+		return false
+	}
+	if historyUser != "" && rec.User != historyUser && rec.Principal != historyUser {
+		return false
+	}
+	if historyMessage != "" && rec.MessageID != historyMessage {
+		return false
+	}
+	if historyEnvironment != "" && rec.Environment != historyEnvironment {
+		return false
+	}
+	if historyFailed && rec.Status == 200 {
+		return false
+	}
+	return true
+}
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "search the local audit log of skip operations",
+	Long: `
+Eg. pigeon-tool history
+Eg. pigeon-tool history --since 24h -q storeeps
+Eg. pigeon-tool history --user cyang02 --failed -o json
+	`,
+	Annotations: map[string]string{"authenticate": authNone},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if historyOutput != "text" && historyOutput != "json" {
+			return fmt.Errorf("unknown output format %q, expecting text or json", historyOutput)
+		}
+		cmd.SilenceUsage = true
+
+		path := auditLogFile()
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "no audit log at %s\n", path)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %s", err.Error())
+		}
+		defer file.Close()
+
+		now := time.Now()
+		records := []auditRecord{}
+		scanner := bufio.NewScanner(file)
+		for line := 1; scanner.Scan(); line++ {
+			var rec auditRecord
+			if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %s:%d: %s\n", path, line, err.Error())
+				continue
+			}
+			if rec.matches(now) {
+				records = append(records, rec)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read audit log: %s", err.Error())
+		}
+
+		if historyOutput == "json" {
+			data, err := json.MarshalIndent(records, "", "    ")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\n", data)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tUSER\tPRINCIPAL\tENV\tHOST\tSUBSCRIPTION\tMESSAGE\tSTATUS\tREASON")
+		for _, rec := range records {
+			status := fmt.Sprint(rec.Status)
+			if rec.Error != "" && rec.Status == 0 {
+				status = "error"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				rec.Time.Local().Format("2006-01-02 15:04:05"), rec.User, rec.Principal, rec.Environment,
+				rec.Host, rec.Subscription, rec.MessageID, status, rec.Reason)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().DurationVar(&historySince, "since", 0, "only show skips newer than this, e.g. 24h")
+	historyCmd.Flags().StringVarP(&historySubscription, "queue", "q", "", "only show subscriptions containing this text")
+	historyCmd.Flags().StringVar(&historyHost, "host", "", "only show skips on this host")
+	historyCmd.Flags().StringVar(&historyUser, "user", "", "only show skips by this OS user or Athenz principal")
+	historyCmd.Flags().StringVarP(&historyMessage, "message", "m", "", "only show skips of this message ID")
+	historyCmd.Flags().StringVar(&historyEnvironment, "env", "", "only show skips in this profile")
+	historyCmd.Flags().BoolVar(&historyFailed, "failed", false, "only show failed skips")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", "text", "output format: text or json")
+}
@@ -1,14 +1,68 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"strings"
+	"log"
+	"os"
+	"time"
+
+	"pigeon-tool/pigeon"
 
 	"github.com/spf13/cobra"
 )
 
 var listNamespace string
+var listOutput string
+var listWatch time.Duration
+
+// reportFailedHosts prints the hosts whose status request failed to stderr
+// and returns an error if there were any.
+func reportFailedHosts(results []pigeon.HostStatus) error {
+	var failed []pigeon.HostStatus
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	// One line instead of the same x509 error for every host.
+	untrusted := 0
+	for _, result := range failed {
+		if needsCABundle(result.Err) {
+			untrusted++
+		}
+	}
+	if untrusted == len(failed) {
+		fmt.Fprintf(os.Stderr, "\n%d of %d hosts failed: %s\n", len(failed), len(results), unknownAuthority)
+		printCABundleHint()
+		return fmt.Errorf("%d of %d hosts failed", len(failed), len(results))
+	}
+
+	fmt.Fprintf(os.Stderr, "\n%d of %d hosts failed:\n", len(failed), len(results))
+	for _, result := range failed {
+		fmt.Fprintf(os.Stderr, "  %s: %s (%s)\n", result.Host, result.Err.Error(), result.Latency.Round(time.Millisecond))
+	}
+	return fmt.Errorf("%d of %d hosts failed", len(failed), len(results))
+}
+
+// validateListFlags checks the list flags. It runs as Args, before the
+// command authenticates.
+func validateListFlags(cmd *cobra.Command, args []string) error {
+	if err := validateOutputFormat(listOutput); err != nil {
+		return err
+	}
+	if listWatch < 0 {
+		return fmt.Errorf("--watch interval must be positive")
+	}
+	if listWatch != 0 && cmd.Flags().Changed("output") {
+		return fmt.Errorf("--watch shows its own view and cannot be combined with --output")
+	}
+	return nil
+}
 
 // listCmd represents the list command
 var listCmd = &cobra.Command{
@@ -17,69 +71,37 @@ var listCmd = &cobra.Command{
 	Long: `
 Eg. pigeon-tool list -n all
 Eg. pigeon-tool list -n NevecTW
+Eg. pigeon-tool list -n all -o json | jq '.[] | select(.count > 10)'
+Eg. pigeon-tool list -n NevecTW -o go-template='{{.Host}} {{.Subscription}} {{.Count}}'
+Eg. pigeon-tool list -n all --watch 10s
 	`,
+	Args: validateListFlags,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		ctx := context.Background()
 
-		var pigeon Information
-		if staging {
-			pigeon.pigeonHostEndpoint = "https://edge.dist.yahoo.com:4443/roles/v1/roles/nevec_egs_pigeon.HOSTs.int/members?output=json"
-		} else {
-			pigeon.pigeonHostEndpoint = "https://edge.dist.yahoo.com:4443/roles/v1/roles/nevec_egs_pigeon.HOSTs.prod/members?output=json"
-		}
-		pigeon.StatusURL = "/api/pigeon/v1/status"
-		pigeon.cert = "/tmp/pigeon_admin_role.cert"
-
-		client, err := getClient("")
+		client, err := newPigeonClient()
 		if err != nil {
 			return err
 		}
-		hosts, err := doGet(client, pigeon.pigeonHostEndpoint)
-		if err != nil {
-			return fmt.Errorf("when getting Pigeon list %s: %s", pigeon.pigeonHostEndpoint, err.Error())
-		}
-		// get pigeon.HostList for later use
-		if err = json.Unmarshal(hosts, &pigeon.HostList); err != nil {
-			return fmt.Errorf("unmarshal fail for getting host list ")
-		}
-		// use role cert to call pigeon api
-		roleClient, err := getClient(pigeon.cert)
+		hosts, err := client.ListHosts(ctx)
 		if err != nil {
 			return err
 		}
 
-		responses := make(chan []byte)
-
-		for _, host := range pigeon.HostList[0].Members {
-			if strings.Contains(host, "tail") {
-				pigeon.tailCount++
-				pigeonStatus := fmt.Sprintf("https://%s:4443%s", host, pigeon.StatusURL)
-				go doGetUseChan(roleClient, pigeonStatus, responses)
-			}
+		if listWatch != 0 {
+			return watchList(ctx, client, hosts, listNamespace, listWatch)
 		}
 
-		for x := 0; x < pigeon.tailCount; x++ {
-			if err = json.Unmarshal(<-responses, &pigeon.StatusResult); err != nil {
-				return fmt.Errorf("unmarshal fail for getting pigeon api ")
-			}
-
-			for _, v := range pigeon.StatusResult.PigeonStatus.Sub {
-				if listNamespace == "all" && v.OldMessageCount != 0 {
-					fmt.Println()
-					fmt.Println(pigeon.StatusResult.Host, v.Property, v.SubscriptionName)
-					for _, id := range v.OldMessages {
-						fmt.Println(id)
-					}
-
-				} else if v.OldMessageCount != 0 && v.Property == listNamespace {
-					fmt.Println(pigeon.StatusResult.Host, v.Property, v.SubscriptionName)
-					for _, id := range v.OldMessages {
-						fmt.Println(id)
-					}
-				}
-			}
+		results := client.StatusAll(ctx, hosts)
+		if err = saveStatusSnapshot(results); err != nil {
+			log.Printf("failed to cache status: %s", err.Error())
+		}
+		if err = printRows(os.Stdout, listOutput, collectRows(results, listNamespace)); err != nil {
+			return err
 		}
 
-		return nil
+		return reportFailedHosts(results)
 	},
 }
 
@@ -87,5 +109,8 @@ func init() {
 	rootCmd.AddCommand(listCmd)
 
 	listCmd.Flags().StringVarP(&listNamespace, "namespace", "n", "", "namespace or all")
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "text", "output format: "+outputFormats)
+	listCmd.Flags().DurationVarP(&listWatch, "watch", "w", 0, "refresh every interval, e.g. 10s, highlighting changes")
 	listCmd.MarkFlagRequired("namespace")
+	listCmd.RegisterFlagCompletionFunc("namespace", completeNamespaces)
 }
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// readMessageIDs reads message IDs for subscription from r. The input is
+// either one ID per line, where blank lines and # comments are ignored, or
+// JSON: an array of IDs, or the output of list -o json, from which the IDs
+// of subscription are taken.
+func readMessageIDs(r io.Reader, subscription string) ([]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) != 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+		return parseMessageIDsJSON(trimmed, subscription)
+	}
+
+	var ids []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	return ids, scanner.Err()
+}
+
+func parseMessageIDsJSON(data []byte, subscription string) ([]string, error) {
+	var plain []string
+	if err := json.Unmarshal(data, &plain); err == nil {
+		return plain, nil
+	}
+
+	// Rows of list -o json, or a single row.
+	var rows []queueRow
+	if data[0] == '{' {
+		var row queueRow
+		if err := json.Unmarshal(data, &row); err != nil {
+			return nil, fmt.Errorf("failed to parse message IDs: %s", err.Error())
+		}
+		rows = append(rows, row)
+	} else if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse message IDs, expecting an array of IDs or list -o json output: %s", err.Error())
+	}
+
+	var ids []string
+	for _, row := range rows {
+		if row.Subscription != "" && row.Subscription != subscription {
+			continue
+		}
+		ids = append(ids, row.MessageIDs...)
+	}
+	return ids, nil
+}
+
+// loadMessageIDs reads the message IDs from path, or stdin if path is "-".
+func loadMessageIDs(path string, subscription string) ([]string, error) {
+	if path == "-" {
+		return readMessageIDs(os.Stdin, subscription)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readMessageIDs(file, subscription)
+}
+
+// selectTargets keeps the targets whose ID is in ids, and returns the IDs
+// that no target has.
+func selectTargets(targets []skipTarget, ids []string) ([]skipTarget, []string) {
+	wanted := make(map[string]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var selected []skipTarget
+	found := make(map[string]bool)
+	for _, t := range targets {
+		if wanted[t.ID] {
+			selected = append(selected, t)
+			found[t.ID] = true
+		}
+	}
+
+	var missing []string
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+			found[id] = true
+		}
+	}
+	return selected, missing
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadMessageIDs(t *testing.T) {
+	const sub = "CQI.prod.a.b::CQO.prod.a.b.c"
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"lines", "m1\nm2\n", []string{"m1", "m2"}, false},
+		{"blank lines and comments", "# old ones\n\n  m1  \r\n#m2\nm3", []string{"m1", "m3"}, false},
+		{"empty", "", nil, false},
+		{"json array", ` ["m1", "m2"] `, []string{"m1", "m2"}, false},
+		{"single row", `{"host":"h1","subscription":"` + sub + `","count":2,"messageIds":["m1","m2"]}`, []string{"m1", "m2"}, false},
+		{
+			"list rows",
+			`[{"host":"h1","subscription":"` + sub + `","messageIds":["m1"]},` +
+				`{"host":"h1","subscription":"CQI.prod.x::CQO.prod.x.y","messageIds":["x1"]},` +
+				`{"host":"h2","subscription":"` + sub + `","messageIds":["m2","m3"]}]`,
+			[]string{"m1", "m2", "m3"},
+			false,
+		},
+		{"row without subscription", `[{"messageIds":["m1"]}]`, []string{"m1"}, false},
+		{"bad array", `["m1", 2]`, nil, true},
+		{"bad row", `{"messageIds":"m1"}`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := readMessageIDs(strings.NewReader(tt.input), sub)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: readMessageIDs() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readMessageIDs() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	targets := []skipTarget{{"h1", "m1"}, {"h1", "m2"}, {"h2", "m1"}}
+	selected, missing := selectTargets(targets, []string{"m1", "m3", "m3"})
+	if want := []skipTarget{{"h1", "m1"}, {"h2", "m1"}}; !reflect.DeepEqual(selected, want) {
+		t.Errorf("selectTargets() selected = %v, want %v", selected, want)
+	}
+	if want := []string{"m3"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("selectTargets() missing = %v, want %v", missing, want)
+	}
+}
@@ -1,28 +1,124 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"pigeon-tool/pigeon"
 
 	"github.com/spf13/cobra"
 )
 
+// namespaceSummary is one namespace of the status of all tail hosts.
+type namespaceSummary struct {
+	Name          string
+	Subscriptions int
+	Stuck         int
+}
+
+// summarizeNamespaces returns the namespaces found on the hosts that
+// answered, sorted by name, with their number of distinct subscriptions and
+// their old messages summed over all hosts.
+func summarizeNamespaces(results []pigeon.HostStatus) []namespaceSummary {
+	subscriptions := make(map[string]map[string]bool)
+	stuck := make(map[string]int)
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		for _, v := range result.Status.PigeonStatus.Sub {
+			if subscriptions[v.Property] == nil {
+				subscriptions[v.Property] = make(map[string]bool)
+			}
+			subscriptions[v.Property][v.SubscriptionName] = true
+			stuck[v.Property] += v.OldMessageCount
+		}
+	}
+
+	summaries := make([]namespaceSummary, 0, len(subscriptions))
+	for name, subs := range subscriptions {
+		summaries = append(summaries, namespaceSummary{Name: name, Subscriptions: len(subs), Stuck: stuck[name]})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// printNamespaces writes summaries to w as a table.
+func printNamespaces(w io.Writer, summaries []namespaceSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tSUBSCRIPTIONS\tSTUCK")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", s.Name, s.Subscriptions, s.Stuck)
+	}
+	return tw.Flush()
+}
+
+// liveStatus loads the profile, authenticates and returns the status of
+// every tail host, or an error if no host could be reached.
+func liveStatus(ctx context.Context, cmd *cobra.Command) ([]pigeon.HostStatus, error) {
+	if err := loadProfile(cmd); err != nil {
+		return nil, err
+	}
+	if err := authenticate(authRole); err != nil {
+		return nil, err
+	}
+	client, err := newPigeonClient()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := client.ListHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := client.StatusAll(ctx, hosts)
+	for _, result := range results {
+		if result.Err == nil {
+			return results, nil
+		}
+	}
+	return results, reportFailedHosts(results)
+}
+
 var namespace = &cobra.Command{
 	Use:   "ns-list",
 	Short: "list all namespace pigeon use",
-	Long:  ``,
-	Run: func(cmd *cobra.Command, args []string) {
-		var ns string = `  
-		AdpostTW
-		AuctionsHK
-		AuctionsTW
-		BillingTW
-		DataMiningTW
-		ECCentralTech
-		NevecTW
-		ShoppingMall
-		Store-TW
-		`
-		fmt.Println(ns)
+	Long: `
+Lists the namespaces found in the status of every tail host with the number
+of subscriptions and stuck messages in each. When no host can be reached the
+status cached by the last successful list or ns-list is used, also when
+authentication fails.
+
+Eg. pigeon-tool ns-list
+	`,
+	// Loads the profile and authenticates in liveStatus, so that the cached
+	// status is shown offline.
+	Annotations: map[string]string{"authenticate": authNone},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+
+		results, err := liveStatus(context.Background(), cmd)
+		if err != nil {
+			snapshot, cacheErr := loadStatusSnapshot()
+			if cacheErr != nil {
+				return fmt.Errorf("%s; %s", err.Error(), cacheErr.Error())
+			}
+			fmt.Fprintf(os.Stderr, "%s\nusing status cached at %s\n\n", err.Error(), snapshot.Time.Format(time.RFC3339))
+			return printNamespaces(os.Stdout, summarizeNamespaces(snapshot.results()))
+		}
+
+		if err = saveStatusSnapshot(results); err != nil {
+			log.Printf("failed to cache status: %s", err.Error())
+		}
+		if err = printNamespaces(os.Stdout, summarizeNamespaces(results)); err != nil {
+			return err
+		}
+		return reportFailedHosts(results)
 	},
 }
 
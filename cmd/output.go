@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"pigeon-tool/pigeon"
+
+	"gopkg.in/yaml.v2"
+)
+
+// queueRow is one subscription with stuck messages on one host.
+type queueRow struct {
+	Host         string   `json:"host" yaml:"host"`
+	Namespace    string   `json:"namespace" yaml:"namespace"`
+	Topic        string   `json:"topic" yaml:"topic"`
+	Subscription string   `json:"subscription" yaml:"subscription"`
+	Count        int      `json:"count" yaml:"count"`
+	MessageIDs   []string `json:"messageIds" yaml:"messageIds"`
+}
+
+// collectRows returns the subscriptions with old messages in namespace, or
+// in every namespace if it is "all", from the hosts that answered.
+func collectRows(results []pigeon.HostStatus, namespace string) []queueRow {
+	rows := []queueRow{}
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		for _, v := range result.Status.PigeonStatus.Sub {
+			if v.OldMessageCount == 0 || (namespace != "all" && v.Property != namespace) {
+				continue
+			}
+			rows = append(rows, queueRow{
+				Host:         result.Host,
+				Namespace:    v.Property,
+				Topic:        v.TopicName,
+				Subscription: v.SubscriptionName,
+				Count:        v.OldMessageCount,
+				MessageIDs:   v.OldMessages,
+			})
+		}
+	}
+	return rows
+}
+
+// outputFormats lists the values accepted by -o.
+const outputFormats = "text, json, yaml, csv, table, ids or go-template=..."
+
+// validateOutputFormat checks a -o value before any request is made.
+func validateOutputFormat(format string) error {
+	if strings.HasPrefix(format, "go-template=") {
+		_, err := template.New("output").Parse(strings.TrimPrefix(format, "go-template="))
+		return err
+	}
+	switch format {
+	case "", "text", "json", "yaml", "csv", "table", "ids":
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expecting %s", format, outputFormats)
+}
+
+// printRows writes rows to w in format.
+func printRows(w io.Writer, format string, rows []queueRow) error {
+	if strings.HasPrefix(format, "go-template=") {
+		// The template is executed once per row.
+		tmpl, err := template.New("output").Parse(strings.TrimPrefix(format, "go-template="))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err = tmpl.Execute(w, row); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	}
+
+	switch format {
+	case "", "text":
+		for _, row := range rows {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, row.Host, row.Namespace, row.Subscription)
+			for _, id := range row.MessageIDs {
+				fmt.Fprintln(w, id)
+			}
+		}
+		return nil
+
+	case "json":
+		data, err := json.MarshalIndent(rows, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+
+	case "yaml":
+		data, err := yaml.Marshal(rows)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"host", "namespace", "topic", "subscription", "count", "message_ids"})
+		for _, row := range rows {
+			cw.Write([]string{row.Host, row.Namespace, row.Topic, row.Subscription, strconv.Itoa(row.Count), strings.Join(row.MessageIDs, ";")})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "HOST\tNAMESPACE\tSUBSCRIPTION\tCOUNT\tOLDEST")
+		for _, row := range rows {
+			oldest := ""
+			if len(row.MessageIDs) != 0 {
+				oldest = row.MessageIDs[0]
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", row.Host, row.Namespace, row.Subscription, row.Count, oldest)
+		}
+		return tw.Flush()
+
+	case "ids":
+		for _, row := range rows {
+			for _, id := range row.MessageIDs {
+				fmt.Fprintln(w, id)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expecting %s", format, outputFormats)
+}
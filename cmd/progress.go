@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressWidth is the number of characters of the progress bar.
+const progressWidth = 30
+
+// progressBar draws a progress bar with an ETA on a terminal.
+type progressBar struct {
+	mu       sync.Mutex
+	w        io.Writer
+	total    int
+	done     int
+	start    time.Time
+	lastDraw time.Time
+}
+
+func newProgressBar(w io.Writer, total int) *progressBar {
+	p := &progressBar{w: w, total: total, start: time.Now()}
+	p.draw()
+	return p
+}
+
+// increment records a finished item and redraws, at most ten times a second.
+func (p *progressBar) increment() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if p.done == p.total || time.Since(p.lastDraw) >= 100*time.Millisecond {
+		p.draw()
+	}
+}
+
+// finish ends the progress bar line.
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(p.w)
+}
+
+func (p *progressBar) draw() {
+	p.lastDraw = time.Now()
+	filled := progressWidth
+	percent := 100
+	if p.total != 0 {
+		filled = progressWidth * p.done / p.total
+		percent = 100 * p.done / p.total
+	}
+
+	eta := "--"
+	if p.done != 0 && p.done < p.total {
+		elapsed := time.Since(p.start)
+		eta = (elapsed * time.Duration(p.total-p.done) / time.Duration(p.done)).Round(time.Second).String()
+	} else if p.done == p.total {
+		eta = "0s"
+	}
+
+	fmt.Fprintf(p.w, "\r[%s%s] %d/%d %3d%% ETA %-8s", strings.Repeat("#", filled), strings.Repeat(".", progressWidth-filled),
+		p.done, p.total, percent, eta)
+}
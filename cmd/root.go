@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -10,20 +11,23 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
 	"strings"
-	"sync"
 	"time"
 
+	"pigeon-tool/pigeon"
+	"pigeon-tool/zts"
+
 	"github.com/spf13/cobra"
 )
 
 const athenzUserCertUtil = "athenz-user-cert"
-const ztsRoleCert = "zts-rolecert"
+
+// roleCertMinLifetime is how long a role certificate must remain valid to be
+// reused instead of fetching a new one.
+const roleCertMinLifetime = 15 * time.Minute
 
 // Global options.
 var roleName string
@@ -31,6 +35,24 @@ var verbose bool
 var keyPath string
 var certPath string
 var staging bool
+var discovery string
+var staticHosts []string
+var hostsFile string
+var dnsName string
+var configPath string
+var caBundle string
+var insecure bool
+var auditLogPath string
+var profileName string
+
+// profile is the resolved environment profile.
+var profile Profile
+
+// roleCertPath is the cached role certificate of the profile.
+var roleCertPath string
+
+// rootCAs verifies the servers the tool talks to.
+var rootCAs *x509.CertPool
 
 func detectSIAKeyCertPair() (e error) {
 	const siaRootDir = "/var/lib/sia"
@@ -120,6 +142,35 @@ func validateKeyCertPair(userKeyPath string, userCertPath string) error {
 	return nil
 }
 
+func validateRoleCert(keyPath string, roleCertPath string, profile Profile) error {
+	log.Println("validating role certificate")
+
+	// The role certificate must belong to the current key.
+	pair, err := tls.LoadX509KeyPair(roleCertPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load role certificate: %s", err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse role certificate: %s", err.Error())
+	}
+	log.Printf("SN=%s, CN=%s, since=%s, until=%s", cert.SerialNumber, cert.Subject.CommonName, cert.NotBefore, cert.NotAfter)
+
+	expected := profile.AthenzDomain + ":role." + profile.RoleName
+	if cert.Subject.CommonName != expected {
+		return fmt.Errorf("role certificate is for %s, not %s", cert.Subject.CommonName, expected)
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.Add(roleCertMinLifetime).After(cert.NotAfter) {
+		return fmt.Errorf("role certificate is expired or about to expire")
+	}
+
+	log.Println("role certificate is still valid")
+	return nil
+}
+
 func execAthenzUserCertUtility() error {
 	log.Println("executing Athenz user-certificate command-line utility")
 
@@ -150,54 +201,42 @@ func execAthenzUserCertUtility() error {
 	return nil
 }
 
-func execZtsCertUtility(keyPath string, certPath string, staging bool, roleName string) error {
-	var athenzDomain string
-	log.Println("executing zts-rolecert command-line utility")
-	if roleName == "" {
-		roleName = "pigeon_admin_role"
-	}
-	if staging {
-		athenzDomain = "nevec.pigeon.int"
-	} else {
-		athenzDomain = "nevec.pigeon.prod"
-	}
-	// Ensure our path includes all directories where the utility could reside.
-	pathenv, pathset := os.LookupEnv("PATH")
-	if pathset {
-		// Path set. We are fine with adding duplicate entries.
-		os.Setenv("PATH", pathenv+":/usr/bin:/usr/local/bin")
-	} else {
-		// Path not set.
-		os.Setenv("PATH", "/usr/bin:/usr/local/bin")
-	}
+func fetchRoleCert(keyPath string, certPath string, roleCertPath string, profile Profile) error {
+	log.Printf("requesting role certificate from %s", profile.ZTSURL)
 
-	path, err := exec.LookPath(ztsRoleCert)
+	identity, err := tls.LoadX509KeyPair(certPath, keyPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to load identity key/cert: %s", err.Error())
 	}
-	log.Printf("found utility: %s", path)
-	// zts-rolecert -svc-key-file ~/.athenz/key -svc-cert-file ~/.athenz/cert -zts https://zts.athens.yahoo.com:4443/zts/v1 -role-domain nevec.pigeon.prod -role-name pigeon_admin_role -dns-domain zts.yahoo.cloud -role-cert-file /tmp/pigeon_admin_role.cert
-	cmd := exec.Command(
-		ztsRoleCert, "-svc-key-file", keyPath, "-svc-cert-file", certPath,
-		"-zts", "https://zts.athens.yahoo.com:4443/zts/v1", "-role-domain",
-		athenzDomain, "-role-name", roleName,
-		"-dns-domain", "zts.yahoo.cloud", "-role-cert-file", "/tmp/pigeon_admin_role.cert")
-	owriter := io.MultiWriter(os.Stdout)
-	cmd.Stdout = owriter
-	cmd.Stderr = owriter
-	if err = cmd.Run(); err != nil {
-		return fmt.Errorf("when executing %s: %s", ztsRoleCert, err.Error())
+
+	client := zts.NewClient(profile.ZTSURL, identity, rootCAs)
+	client.Logger = debugLogger()
+	cert, err := client.RoleCertificate(context.Background(), zts.RoleCertRequest{
+		Identity:  identity,
+		Domain:    profile.AthenzDomain,
+		Role:      profile.RoleName,
+		DNSDomain: profile.DNSDomain,
+	})
+	if err != nil {
+		return fmt.Errorf("when requesting role certificate: %s", err.Error())
 	}
 
+	if err = writeFileAtomic(roleCertPath, cert, 0600); err != nil {
+		return fmt.Errorf("failed to write role certificate: %s", err.Error())
+	}
+	log.Printf("wrote role certificate: %s", roleCertPath)
 	return nil
 }
 
+func athenzUserKeyCertPaths(me *user.User) (string, string) {
+	userIdDir := me.HomeDir + "/.athenz"
+	return userIdDir + "/key", userIdDir + "/cert"
+}
+
 func detectAthenzUserKeyCertPair(me *user.User) error {
 	log.Printf("detecting Athenz identity for user: %s\n", me.Username)
 
-	userIdDir := me.HomeDir + "/.athenz"
-	keyPath = userIdDir + "/key"
-	certPath = userIdDir + "/cert"
+	keyPath, certPath = athenzUserKeyCertPaths(me)
 	if err := validateKeyCertPair(keyPath, certPath); err != nil {
 		if err = execAthenzUserCertUtility(); err != nil {
 			return err
@@ -220,129 +259,187 @@ func getKeyCertPair() error {
 	return detectAthenzUserKeyCertPair(me)
 }
 
-func getClient(cert string) (*http.Client, error) {
-	// Note that order of key and cert is reversed from my convention.
-	if cert == "" {
-		cert = certPath
-	}
-	log.Printf("loading key %s and cert %s", keyPath, cert)
-	pair, err := tls.LoadX509KeyPair(cert, keyPath)
+// loadRootCAs returns the system certificate pool plus the CAs in bundle.
+func loadRootCAs(bundle string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
 	if err != nil {
-		return nil, err
+		log.Printf("failed to load system certificate pool: %s", err.Error())
+		pool = x509.NewCertPool()
+	}
+	if bundle == "" {
+		return pool, nil
 	}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			Certificates:       []tls.Certificate{pair},
-			InsecureSkipVerify: true,
-		},
-		Dial: (&net.Dialer{
-			Timeout:   10 * time.Second,
-			KeepAlive: 10 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout:   3 * time.Second,
-		ResponseHeaderTimeout: 3 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
+	data, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %s", err.Error())
 	}
-	client := &http.Client{
-		Transport: transport,
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", bundle)
 	}
-
-	return client, nil
+	log.Printf("loaded CA bundle: %s", bundle)
+	return pool, nil
 }
 
-func doGeneric(client *http.Client, request *http.Request, expectedCode int) ([]byte, error) {
-	log.Printf("issuing %s to URL: %s", request.Method, request.URL)
+// Authentication requirement levels. Commands declare theirs with the
+// "authenticate" annotation; commands without one require a role certificate.
+const (
+	// authNone runs the command without any credentials.
+	authNone = "none"
+	// authRole detects the user key and certificate and makes sure a valid
+	// role certificate is cached.
+	authRole = "role"
+)
 
-	response, err := client.Do(request)
-	if err != nil {
-		return nil, fmt.Errorf("transaction error: %s", err.Error())
+// authRequirement returns the authentication level cmd requires.
+func authRequirement(cmd *cobra.Command) (string, error) {
+	// The help and completion request commands are added by cobra.
+	if cmd.Name() == "help" || cmd.Name() == cobra.ShellCompRequestCmd {
+		return authNone, nil
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("error when reading response body: %s", err.Error())
+	level, ok := cmd.Annotations["authenticate"]
+	switch {
+	case !ok:
+		return authRole, nil
+	case level == "no":
+		// Older spelling of none.
+		return authNone, nil
+	case level == authNone || level == authRole:
+		return level, nil
 	}
+	return "", fmt.Errorf("command %s has unknown authentication requirement %q", cmd.Name(), level)
+}
 
-	if response.StatusCode != expectedCode {
-		return nil, fmt.Errorf("server returned code %d with message: %s", response.StatusCode, body)
+// loadProfile resolves the profile and loads the CAs its servers are
+// verified against.
+func loadProfile(cmd *cobra.Command) error {
+	if err := resolveProfile(cmd); err != nil {
+		return err
 	}
 
-	return body, nil
+	if insecure {
+		fmt.Fprintln(os.Stderr, "warning: TLS server verification is disabled")
+	}
+	var err error
+	rootCAs, err = loadRootCAs(profile.CABundle)
+	return err
 }
 
-func doPutUseChan(client *http.Client, url string, payload []byte, expectedCode int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	request, err := http.NewRequest("PUT", url, bytes.NewReader(payload))
-	if err != nil {
-		log.Fatalf("failed to construct PUT request: %s", err.Error())
+// authenticate acquires the credentials of level: for authRole the user key
+// and certificate, and a role certificate that is fetched from ZTS unless a
+// valid one is cached.
+func authenticate(level string) error {
+	if level == authNone {
+		return nil
 	}
-	request.Header.Set("Content-Type", "application/json")
 
-	_, err = doGeneric(client, request, expectedCode)
-	if err != nil {
-		log.Fatalf("%s with error: %s", url, err.Error())
+	// If needed, autodetect key/cert paths.
+	if keyPath == "" || certPath == "" {
+		log.Println("invalid key/cert specification")
+		if err := getKeyCertPair(); err != nil {
+			return err
+		}
+		log.Printf("detected key path: %s", keyPath)
+		log.Printf("detected cert path: %s", certPath)
 	}
-}
 
-func doPut(client *http.Client, url string, payload []byte, expectedCode int) error {
+	var err error
+	if roleCertPath, err = roleCertFile(profileName, profile); err != nil {
+		return err
+	}
 
-	request, err := http.NewRequest("PUT", url, bytes.NewReader(payload))
+	// Serialize the check and refresh with concurrent invocations.
+	unlock, err := lockFile(roleCertPath + ".lock")
 	if err != nil {
-		log.Printf("failed to construct PUT request: %s", err.Error())
-		return fmt.Errorf("failed to construct PUT request: %s", err.Error())
+		return fmt.Errorf("failed to lock role certificate: %s", err.Error())
 	}
-	request.Header.Set("Content-Type", "application/json")
+	defer unlock()
 
-	_, err = doGeneric(client, request, expectedCode)
-	if err != nil {
-		log.Printf("%s with error: %s", url, err.Error())
-		return err
+	// Reuse the role certificate until it is about to expire.
+	if err = validateRoleCert(keyPath, roleCertPath, profile); err != nil {
+		log.Printf("refreshing role certificate: %s", err.Error())
+		if err = fetchRoleCert(keyPath, certPath, roleCertPath, profile); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func doGetUseChan(client *http.Client, url string, ch chan<- []byte) error {
-	log.Printf("issuing GET to URL: %s", url)
+// unknownAuthority is how crypto/x509 reports a server certificate signed by a
+// CA outside the trusted pool.
+const unknownAuthority = "x509: certificate signed by unknown authority"
 
-	response, err := client.Get(url)
-	if err != nil {
-		return fmt.Errorf("transaction error: %s", err.Error())
-	}
-
-	body, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-	if err != nil {
-		return fmt.Errorf("error when reading response body: %s", err.Error())
-	}
+// needsCABundle tells if err is an unknown CA while the profile trusts only
+// the system pool, which lacks the Athenz CA on most machines.
+func needsCABundle(err error) bool {
+	return err != nil && profile.CABundle == "" && strings.Contains(err.Error(), unknownAuthority)
+}
 
-	if response.StatusCode != 200 {
-		return fmt.Errorf("server returned code %d with message: %s", response.StatusCode, body)
-	}
+// printCABundleHint points to the ca_bundle setting.
+func printCABundleHint() {
+	fmt.Fprintf(os.Stderr, "the server certificate is not signed by a CA in the system pool; set ca_bundle in the %s profile or pass --ca-bundle with the Athenz CA certificates\n", profileName)
+}
 
-	ch <- body
-	return nil
+// debugLogger returns a logger writing where the standard logger does, which
+// is nowhere unless -v is given.
+func debugLogger() *log.Logger {
+	return log.New(log.Writer(), "", log.Flags())
 }
-func doGet(client *http.Client, url string) ([]byte, error) {
-	log.Printf("issuing GET to URL: %s", url)
 
-	response, err := client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("transaction error: %s", err.Error())
+// newHostDiscoverer returns the host discovery backend selected by the
+// profile. A nil discoverer means the Athenz role-members lookup.
+func newHostDiscoverer() (pigeon.HostDiscoverer, error) {
+	backend := profile.Discovery
+	if backend == "" {
+		switch {
+		case len(profile.Hosts) != 0 || profile.HostsFile != "":
+			backend = "static"
+		case profile.DNSName != "":
+			backend = "dns"
+		default:
+			backend = "role"
+		}
 	}
+	log.Printf("using %s host discovery", backend)
 
-	body, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("error when reading response body: %s", err.Error())
+	switch backend {
+	case "role":
+		return nil, nil
+	case "static":
+		if profile.HostsFile != "" {
+			return pigeon.LoadStaticHosts(profile.HostsFile)
+		}
+		return pigeon.StaticHosts(profile.Hosts), nil
+	case "dns", "dns-srv":
+		if profile.DNSName == "" {
+			return nil, fmt.Errorf("a DNS name is required for %s discovery", backend)
+		}
+		return &pigeon.DNS{Name: profile.DNSName, SRV: backend == "dns-srv"}, nil
 	}
+	return nil, fmt.Errorf("unknown discovery backend %q, expecting role, static, dns or dns-srv", backend)
+}
 
-	if response.StatusCode != 200 {
-		return nil, fmt.Errorf("server returned code %d with message: %s", response.StatusCode, body)
+// newPigeonClient returns a pigeon.Client for the selected environment.
+func newPigeonClient() (*pigeon.Client, error) {
+	discoverer, err := newHostDiscoverer()
+	if err != nil {
+		return nil, err
 	}
-
-	return body, nil
+	opts := pigeon.Options{
+		KeyPath:      keyPath,
+		CertPath:     certPath,
+		RoleCertPath: roleCertPath,
+		Discoverer:   discoverer,
+		HostEndpoint: profile.HostEndpoint,
+		RootCAs:      rootCAs,
+		Insecure:     insecure,
+		StatusURL:    profile.StatusURL,
+		SkipURL:      profile.SkipURL,
+		Port:         profile.Port,
+		Logger:       debugLogger(),
+	}
+	return pigeon.NewClient(opts)
 }
 
 func printJSON(body []byte) error {
@@ -364,6 +461,8 @@ func printJSON(body []byte) error {
 var rootCmd = &cobra.Command{
 	Use:   "pigeon-tool",
 	Short: "pigeon queue management",
+	// Execute prints the error.
+	SilenceErrors: true,
 	Long: `
 list all namespace
 Eg. pigeon-tool ns-list
@@ -392,29 +491,21 @@ Eg. pigeon-tool -i skip -q CQI.int.nevec.merchandise.event.all::CQO.int.nevec.me
 			log.SetOutput(ioutil.Discard)
 		}
 
-		// Avoid autodetecting certificate and key if requested.
-		//
-		// To skip autodetection, commands should annotate themselves with
-		// authenticate=no.
-		if shouldAuthenticate, ok := cmd.Annotations["authenticate"]; ok && shouldAuthenticate == "no" {
+		// Commands that run without credentials load the profile
+		// themselves if they need it.
+		level, err := authRequirement(cmd)
+		if err != nil {
+			return err
+		}
+		if level == authNone {
 			log.Println("skipping authentication for this command")
-		} else {
-			// If needed, autodetect key/cert paths.
-			if keyPath == "" || certPath == "" {
-				log.Println("invalid key/cert specification")
-				if err := getKeyCertPair(); err != nil {
-					return err
-				}
-				log.Printf("detected key path: %s", keyPath)
-				log.Printf("detected cert path: %s", certPath)
-			}
+			return nil
 		}
 
-		if err := execZtsCertUtility(keyPath, certPath, staging, roleName); err != nil {
+		if err = loadProfile(cmd); err != nil {
 			return err
 		}
-
-		return nil
+		return authenticate(level)
 	},
 }
 
@@ -423,15 +514,27 @@ Eg. pigeon-tool -i skip -q CQI.int.nevec.merchandise.event.all::CQO.int.nevec.me
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
+		if needsCABundle(err) {
+			printCABundleHint()
+		}
 		os.Exit(1)
 	}
 }
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output for debug")
-	rootCmd.PersistentFlags().StringVarP(&roleName, "role", "r", "pigeon_admin_role", "zts role or you can skip it")
+	rootCmd.PersistentFlags().StringVarP(&roleName, "role", "r", "", "zts role, defaults to the profile's role_name (pigeon_admin_role)")
 	rootCmd.PersistentFlags().StringVarP(&keyPath, "key", "k", "", "path to PKI key file or you can skip it")
 	rootCmd.PersistentFlags().StringVarP(&certPath, "certificate", "c", "", "path to PKI certificate file or you can skip it")
-	rootCmd.PersistentFlags().BoolVarP(&staging, "int", "i", false, "operation in int environment")
+	rootCmd.PersistentFlags().BoolVarP(&staging, "int", "i", false, "operation in int environment, same as --profile int")
+	rootCmd.PersistentFlags().StringVarP(&profileName, "profile", "p", "", "environment profile from the config file (default prod)")
+	rootCmd.PersistentFlags().StringVar(&caBundle, "ca-bundle", "", "PEM file of CAs trusted in addition to the system pool")
+	rootCmd.PersistentFlags().BoolVar(&insecure, "insecure", false, "skip TLS verification of the pigeon and host discovery servers")
+	rootCmd.PersistentFlags().StringVar(&auditLogPath, "audit-log", "", "skip audit log (default $XDG_STATE_HOME/pigeon-tool/audit.jsonl)")
+	rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "config file (default $XDG_CONFIG_HOME/pigeon-tool/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&discovery, "discovery", "", "host discovery backend: role, static, dns or dns-srv")
+	rootCmd.PersistentFlags().StringSliceVar(&staticHosts, "hosts", nil, "comma-separated pigeon tail hosts for static discovery")
+	rootCmd.PersistentFlags().StringVar(&hostsFile, "hosts-file", "", "file listing pigeon tail hosts for static discovery")
+	rootCmd.PersistentFlags().StringVar(&dnsName, "dns-name", "", "DNS name to resolve for dns or dns-srv discovery")
 	//rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
@@ -1,16 +1,86 @@
 package cmd
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"strings"
-	"sync"
+	"os"
+	"os/signal"
+	"time"
+
+	"pigeon-tool/pigeon"
 
 	"github.com/spf13/cobra"
 )
 
 var message string
 var queue string
+var skipDryRun bool
+var skipYes bool
+var skipReason string
+var skipFailFast bool
+var skipConcurrency int
+var skipRate float64
+var skipCheckpoint string
+var skipResume string
+var skipFromFile string
+var skipBroadcast bool
+var skipVerify bool
+var skipVerifyWait time.Duration
+var skipVerifyRetries int
+var skipForce bool
+
+// skipTarget is one message to skip on one host.
+type skipTarget struct {
+	Host string `json:"host"`
+	ID   string `json:"id"`
+}
+
+// oldMessageTargets returns every old message of subscription on the hosts
+// that answered.
+func oldMessageTargets(results []pigeon.HostStatus, subscription string) []skipTarget {
+	var targets []skipTarget
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		for _, v := range result.Status.PigeonStatus.Sub {
+			if v.SubscriptionName == subscription && v.OldMessageCount != 0 {
+				for _, id := range v.OldMessages {
+					targets = append(targets, skipTarget{Host: result.Host, ID: id})
+				}
+			}
+		}
+	}
+	return targets
+}
+
+// skipMessage skips t and records the attempt in the audit log.
+func skipMessage(ctx context.Context, client *pigeon.Client, audit *auditLog, subscription pigeon.SubscriptionName, t skipTarget) error {
+	err := client.SkipMessage(ctx, t.Host, subscription, t.ID)
+	if auditErr := audit.record(t.Host, t.ID, err); auditErr != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write audit log: %s\n", auditErr.Error())
+	}
+	return err
+}
+
+// verify checks that the skipped messages left the stuck list, if --verify
+// was given.
+func verify(ctx context.Context, client *pigeon.Client, subscription string, skipped []skipTarget) error {
+	if !skipVerify || len(skipped) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "verifying %d skipped messages\n", len(skipped))
+	remaining := verifySkipped(ctx, client, subscription, skipped, skipVerifyWait, skipVerifyRetries)
+	return printVerifyReport(os.Stdout, skipped, remaining)
+}
+
+// printSkipPlan prints the PUT requests skipping targets would issue.
+func printSkipPlan(client *pigeon.Client, subscription pigeon.SubscriptionName, targets []skipTarget) {
+	fmt.Printf("dry run, would issue %d PUT requests:\n", len(targets))
+	for _, t := range targets {
+		fmt.Printf("PUT %s\n", client.SkipMessageURL(t.Host, subscription, t.ID))
+	}
+}
 
 // skipCmd represents the skip command
 var skipCmd = &cobra.Command{
@@ -19,85 +89,299 @@ var skipCmd = &cobra.Command{
 	Long: `
 Eg. pigeon-tool skip -q CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin -m d925d129-e4e7-4602-bba4-124bf462bc5c__08959ef907109ef601
 Eg. pigeon-tool skip -q CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin -m all
+Eg. pigeon-tool skip -q CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin -m all --dry-run
+
+A single message is only skipped on the hosts that report it as an old
+message of the subscription; --broadcast sends the skip to every host.
+
+With -m all, a summary of the messages is shown and the subscription name
+must be typed to confirm, unless --yes is given. Progress is written to a
+checkpoint file; an interrupted skip continues with --resume <file>.
+
+Several messages can be read from a file or stdin, one ID per line or as JSON:
+Eg. pigeon-tool list -n NevecTW -o ids | grep ... | pigeon-tool skip -q CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin -m -
+Eg. pigeon-tool skip -q CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin --from-file ids.txt
+Eg. pigeon-tool skip --resume ~/.cache/pigeon-tool/checkpoints/20200601-101500-CQI.prod.storeeps.set.action__CQO.prod.storeeps.set.action.search.merlin.jsonl
 	`,
+	Args: validateSkipFlags,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var pigeon Information
-		if staging {
-			pigeon.pigeonHostEndpoint = "https://edge.dist.yahoo.com:4443/roles/v1/roles/nevec_egs_pigeon.HOSTs.int/members?output=json"
-		} else {
-			pigeon.pigeonHostEndpoint = "https://edge.dist.yahoo.com:4443/roles/v1/roles/nevec_egs_pigeon.HOSTs.prod/members?output=json"
-		}
-		pigeon.StatusURL = "/api/pigeon/v1/status"
-		pigeon.SkipURL = "/api/pigeon/v1/messages/skip/"
-		pigeon.cert = "/tmp/pigeon_admin_role.cert"
+		cmd.SilenceUsage = true
+		ctx := context.Background()
 
-		client, err := getClient("")
+		client, err := newPigeonClient()
 		if err != nil {
 			return err
 		}
-		hosts, err := doGet(client, pigeon.pigeonHostEndpoint)
-		if err != nil {
-			return fmt.Errorf("when getting Pigeon list %s: %s", pigeon.pigeonHostEndpoint, err.Error())
-		}
-		// get pigeon.HostList for later use
-		if err = json.Unmarshal(hosts, &pigeon.HostList); err != nil {
-			return fmt.Errorf("unmarshal fail for getting host list ")
+		plan, err := planSkip(ctx, client)
+		if plan != nil && plan.Checkpoint != nil {
+			defer plan.Checkpoint.Close()
 		}
-		// use role cert to call pigeon api
-		roleClient, err := getClient(pigeon.cert)
 		if err != nil {
 			return err
 		}
+		return executeSkip(ctx, client, plan)
+	},
+}
 
-		responses := make(chan []byte)
-		var wg sync.WaitGroup
-		if message == "all" {
-			// save message id to channel
+// validateSkipFlags checks the combination of skip flags and the
+// subscription name. It runs as Args, before the command authenticates.
+func validateSkipFlags(cmd *cobra.Command, args []string) error {
+	if skipResume == "" && (queue == "" || (message == "" && skipFromFile == "")) {
+		return fmt.Errorf(`required flag(s) "queue", "message" not set`)
+	}
+	if skipResume != "" && (queue != "" || message != "" || skipFromFile != "") {
+		return fmt.Errorf("--resume takes the queue and messages from the checkpoint file")
+	}
+	if message != "" && skipFromFile != "" {
+		return fmt.Errorf("--message and --from-file cannot be used together")
+	}
+	if message == "-" {
+		skipFromFile = "-"
+	}
+	if skipBroadcast && (message == "all" || skipFromFile != "" || skipResume != "") {
+		return fmt.Errorf("--broadcast only applies to a single message ID")
+	}
+	if skipConcurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if skipRate < 0 {
+		return fmt.Errorf("--rate must not be negative")
+	}
+	if skipVerifyRetries < 1 {
+		return fmt.Errorf("--verify-retries must be at least 1")
+	}
+	if skipVerifyWait < 0 {
+		return fmt.Errorf("--verify-wait must not be negative")
+	}
+	if queue != "" {
+		if _, err := pigeon.ParseSubscriptionName(queue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// call pigeon status api parallely
-			for _, host := range pigeon.HostList[0].Members {
-				if strings.Contains(host, "tail") {
-					pigeon.tailCount++
-					pigeonStatus := fmt.Sprintf("https://%s:4443%s", host, pigeon.StatusURL)
-					go doGetUseChan(roleClient, pigeonStatus, responses)
-				}
-			}
+// skipPlan is the messages a skip command sends requests for, and what was
+// found while looking them up.
+type skipPlan struct {
+	Name    pigeon.SubscriptionName
+	Targets []skipTarget
+	// Results are the status lookups, if the targets were looked up.
+	Results []pigeon.HostStatus
+	// Missing are the requested IDs not found on any host.
+	Missing []string
+	// Bulk is set for several messages, which are confirmed and recorded
+	// in Checkpoint.
+	Bulk       bool
+	Checkpoint *checkpoint
+}
 
-			// get the result then parse the messageID
-			for x := 0; x < pigeon.tailCount; x++ {
-				if err = json.Unmarshal(<-responses, &pigeon.StatusResult); err != nil {
-					return fmt.Errorf("unmarshal fail for getting pigeon api ")
-				}
-				for _, v := range pigeon.StatusResult.PigeonStatus.Sub {
-					if v.SubscriptionName == queue && v.OldMessageCount != 0 {
-						for _, id := range v.OldMessages {
-							url := fmt.Sprintf("https://%s:4443%s%s?msgId=%s", pigeon.StatusResult.Host, pigeon.SkipURL, queue, id)
-							wg.Add(1)
-							go doPutUseChan(roleClient, url, nil, 200, &wg)
-
-						}
-						wg.Wait()
-					}
-				}
+// planSkip builds the plan for the mode selected by the flags: resuming a
+// checkpoint, broadcasting one message, or looking up one message, a batch
+// of messages or all messages in the status of the hosts.
+func planSkip(ctx context.Context, client *pigeon.Client) (*skipPlan, error) {
+	if skipResume != "" {
+		return planResume()
+	}
+
+	name, err := pigeon.ParseSubscriptionName(queue)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkEnvironment(name); err != nil {
+		if !skipForce {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+	}
+
+	var ids []string
+	if skipFromFile != "" {
+		if ids, err = loadMessageIDs(skipFromFile, queue); err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("no message IDs read from %s", skipFromFile)
+		}
+	}
+
+	hosts, err := client.ListHosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan := &skipPlan{Name: name}
+
+	if skipBroadcast {
+		// Send the single message to every host, whether it holds the
+		// message or not.
+		for _, host := range hosts {
+			plan.Targets = append(plan.Targets, skipTarget{Host: host, ID: message})
+		}
+		return plan, nil
+	}
+
+	// call pigeon status api parallely, then collect the messageIDs
+	plan.Results = client.StatusAll(ctx, hosts)
+	if err = checkSubscription(plan.Results, queue); err != nil {
+		return nil, err
+	}
+	plan.Targets = oldMessageTargets(plan.Results, queue)
+
+	switch {
+	case ids != nil:
+		plan.Bulk = true
+		plan.Targets, plan.Missing = selectTargets(plan.Targets, ids)
+	case message == "all":
+		plan.Bulk = true
+	default:
+		// Only the hosts holding the message.
+		plan.Targets, _ = selectTargets(plan.Targets, []string{message})
+		if len(plan.Targets) == 0 {
+			reportFailedHosts(plan.Results)
+			return nil, fmt.Errorf("message %s is not an old message of %s on any host; use --broadcast to send the skip to every host anyway", message, queue)
+		}
+	}
+	return plan, nil
+}
+
+// planResume builds the plan of the messages a checkpoint has not recorded
+// as skipped.
+func planResume() (*skipPlan, error) {
+	cp, err := openCheckpoint(skipResume)
+	if err != nil {
+		return nil, err
+	}
+	plan := &skipPlan{Bulk: true, Checkpoint: cp}
+	if cp.plan.Profile != profileName {
+		return plan, fmt.Errorf("checkpoint was made with profile %s, not %s", cp.plan.Profile, profileName)
+	}
+	queue = cp.plan.Subscription
+	if plan.Name, err = pigeon.ParseSubscriptionName(queue); err != nil {
+		return plan, err
+	}
+	plan.Targets = cp.remaining()
+	fmt.Fprintf(os.Stderr, "resuming %s: %d of %d messages already skipped\n", skipResume, len(cp.done), len(cp.plan.Targets))
+	return plan, nil
+}
+
+// executeSkip sends the skip requests of plan and reports the outcome.
+func executeSkip(ctx context.Context, client *pigeon.Client, plan *skipPlan) error {
+	if len(plan.Missing) != 0 {
+		fmt.Fprintf(os.Stderr, "%d message IDs are not old messages of %s on any host:\n", len(plan.Missing), queue)
+		for _, id := range plan.Missing {
+			fmt.Fprintf(os.Stderr, "  %s\n", id)
+		}
+	}
+
+	if skipDryRun {
+		printSkipPlan(client, plan.Name, plan.Targets)
+		return reportFailedHosts(plan.Results)
+	}
+
+	switch {
+	case len(plan.Targets) != 0:
+	case plan.Checkpoint != nil:
+		fmt.Printf("every message in %s was already skipped\n", plan.Checkpoint.path)
+		return nil
+	case len(plan.Missing) != 0:
+		reportFailedHosts(plan.Results)
+		return fmt.Errorf("none of the %d requested message IDs is an old message of %s", len(plan.Missing), queue)
+	default:
+		fmt.Printf("no old messages found for %s\n", queue)
+		return reportFailedHosts(plan.Results)
+	}
+
+	audit, err := openAuditLog(queue, skipReason)
+	if err != nil {
+		return err
+	}
+	defer audit.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	opts := bulkOptions{
+		FailFast:    skipFailFast,
+		Concurrency: skipConcurrency,
+		Rate:        skipRate,
+		Interrupt:   interrupt,
+	}
+
+	if plan.Bulk {
+		printSkipSummary(os.Stderr, queue, plan.Targets)
+		if !skipYes {
+			if err = confirmSkip(queue, skipFromFile == "-"); err != nil {
+				return err
 			}
+		}
 
-		} else {
-			for _, host := range pigeon.HostList[0].Members {
-				if strings.Contains(host, "tail") {
-					url := fmt.Sprintf("https://%s:4443%s%s?msgId=%s", host, pigeon.SkipURL, queue, message)
-					doPut(roleClient, url, nil, 200)
+		if plan.Checkpoint == nil {
+			path := skipCheckpoint
+			if path == "" {
+				if path, err = defaultCheckpointPath(queue); err != nil {
+					return err
 				}
 			}
+			if plan.Checkpoint, err = createCheckpoint(path, profileName, queue, plan.Targets); err != nil {
+				return err
+			}
+			defer plan.Checkpoint.Close()
 		}
+		fmt.Fprintf(os.Stderr, "checkpoint: %s\n", plan.Checkpoint.path)
 
-		return nil
-	},
+		opts.Checkpoint = plan.Checkpoint
+		if isTerminal(os.Stderr) {
+			opts.Progress = newProgressBar(os.Stderr, len(plan.Targets))
+		}
+	}
+
+	outcomes := runBulkSkip(ctx, client, audit, plan.Name, plan.Targets, opts)
+	reportErr := printSkipReport(os.Stdout, outcomes)
+	if reportErr != nil && plan.Checkpoint != nil {
+		fmt.Fprintf(os.Stderr, "continue with: pigeon-tool skip --resume %s\n", plan.Checkpoint.path)
+	}
+
+	var skipped []skipTarget
+	untrusted := false
+	for _, outcome := range outcomes {
+		if outcome.Attempted && outcome.Err == nil {
+			skipped = append(skipped, outcome.Target)
+		}
+		untrusted = untrusted || needsCABundle(outcome.Err)
+	}
+	if untrusted {
+		printCABundleHint()
+	}
+	if err = verify(ctx, client, queue, skipped); err != nil && reportErr == nil {
+		reportErr = err
+	}
+	if reportErr == nil && len(plan.Missing) != 0 {
+		reportErr = fmt.Errorf("%d message IDs were not found", len(plan.Missing))
+	}
+	if err = reportFailedHosts(plan.Results); err != nil {
+		return err
+	}
+	return reportErr
 }
 
 func init() {
 	rootCmd.AddCommand(skipCmd)
 	skipCmd.Flags().StringVarP(&queue, "queue", "q", "", "SubscriptionName")
-	skipCmd.Flags().StringVarP(&message, "message", "m", "", "Message_id or [all]")
-	skipCmd.MarkFlagRequired("queue")
-	skipCmd.MarkFlagRequired("message")
+	skipCmd.Flags().StringVarP(&message, "message", "m", "", "Message_id, [all], or - to read IDs from stdin")
+	skipCmd.Flags().StringVar(&skipFromFile, "from-file", "", "read message IDs from a file, one per line or JSON")
+	skipCmd.Flags().BoolVar(&skipBroadcast, "broadcast", false, "send a single message skip to every host without looking up which holds it")
+	skipCmd.Flags().BoolVar(&skipVerify, "verify", false, "re-poll the status afterwards and report messages still listed")
+	skipCmd.Flags().DurationVar(&skipVerifyWait, "verify-wait", 2*time.Second, "wait before each verification poll")
+	skipCmd.Flags().IntVar(&skipVerifyRetries, "verify-retries", 3, "number of verification polls")
+	skipCmd.Flags().BoolVar(&skipForce, "force", false, "skip even if the queue environment does not match the profile")
+	skipCmd.Flags().BoolVar(&skipDryRun, "dry-run", false, "print the skip requests without sending them")
+	skipCmd.Flags().BoolVarP(&skipYes, "yes", "y", false, "do not ask for confirmation before skipping all messages")
+	skipCmd.Flags().StringVar(&skipReason, "reason", "", "reason recorded in the audit log, e.g. a ticket number")
+	skipCmd.Flags().BoolVar(&skipFailFast, "fail-fast", false, "stop sending skip requests after the first failure")
+	skipCmd.Flags().IntVar(&skipConcurrency, "concurrency", 8, "number of skip requests in flight at once")
+	skipCmd.Flags().Float64Var(&skipRate, "rate", 0, "maximum skip requests per second to each host, 0 for no limit")
+	skipCmd.Flags().StringVar(&skipCheckpoint, "checkpoint", "", "checkpoint file for a bulk skip (default in $XDG_CACHE_HOME/pigeon-tool/checkpoints)")
+	skipCmd.Flags().StringVar(&skipResume, "resume", "", "continue the bulk skip recorded in a checkpoint file")
+	skipCmd.RegisterFlagCompletionFunc("queue", completeSubscriptions)
+	skipCmd.RegisterFlagCompletionFunc("message", completeMessageIDs)
 }
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pigeon-tool/pigeon"
+)
+
+// statusSnapshot is the last status of every tail host that answered, cached
+// per profile for use when the hosts cannot be reached.
+type statusSnapshot struct {
+	Time     time.Time      `json:"time"`
+	Profile  string         `json:"profile"`
+	Statuses []cachedStatus `json:"statuses"`
+}
+
+// cachedStatus is the status of one host, which Host is the name it was
+// queried by.
+type cachedStatus struct {
+	Host   string         `json:"host"`
+	Status pigeon.Outmost `json:"status"`
+}
+
+// snapshotFile returns where the status snapshot of a profile is cached.
+func snapshotFile(name string) (string, error) {
+	dir, err := cacheDir()
+	if err != nil {
+		return "", err
+	}
+	name = strings.Replace(name, string(filepath.Separator), "-", -1)
+	return filepath.Join(dir, "status_"+name+".json"), nil
+}
+
+// saveStatusSnapshot caches the status of the hosts that answered. Nothing is
+// written if no host answered.
+func saveStatusSnapshot(results []pigeon.HostStatus) error {
+	snapshot := statusSnapshot{Time: time.Now(), Profile: profileName}
+	for _, result := range results {
+		if result.Err == nil {
+			snapshot.Statuses = append(snapshot.Statuses, cachedStatus{Host: result.Host, Status: *result.Status})
+		}
+	}
+	if len(snapshot.Statuses) == 0 {
+		return nil
+	}
+
+	path, err := snapshotFile(profileName)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// loadStatusSnapshot reads the cached status of the current profile.
+func loadStatusSnapshot() (*statusSnapshot, error) {
+	path, err := snapshotFile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no cached status for profile %s: %s", profileName, err.Error())
+	}
+	var snapshot statusSnapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	return &snapshot, nil
+}
+
+// results returns the snapshot in the form returned by StatusAll.
+func (s *statusSnapshot) results() []pigeon.HostStatus {
+	results := make([]pigeon.HostStatus, len(s.Statuses))
+	for i := range s.Statuses {
+		results[i] = pigeon.HostStatus{Host: s.Statuses[i].Host, Status: &s.Statuses[i].Status}
+	}
+	return results
+}
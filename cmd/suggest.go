@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+
+	"pigeon-tool/pigeon"
+)
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// closestMatch returns the candidate nearest to name, or "" if none is
+// within a third of the length of name.
+func closestMatch(name string, candidates []string) string {
+	best, bestDistance := "", len(name)/3+1
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// checkSubscription returns an error, with a suggestion if one is close, if
+// subscription is not in the status of any host that answered. Nothing is
+// checked if no host answered.
+func checkSubscription(results []pigeon.HostStatus, subscription string) error {
+	var names []string
+	seen := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		for _, v := range result.Status.PigeonStatus.Sub {
+			if v.SubscriptionName == subscription {
+				return nil
+			}
+			if !seen[v.SubscriptionName] {
+				seen[v.SubscriptionName] = true
+				names = append(names, v.SubscriptionName)
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+
+	if suggestion := closestMatch(subscription, names); suggestion != "" {
+		return fmt.Errorf("subscription %s does not exist on any host; did you mean %s?", subscription, suggestion)
+	}
+	return fmt.Errorf("subscription %s does not exist on any host", subscription)
+}
+
+// checkEnvironment returns an error if a queue of name belongs to another
+// environment than the current profile.
+func checkEnvironment(name pigeon.SubscriptionName) error {
+	if profile.Environment == "" {
+		return nil
+	}
+	for _, q := range []pigeon.Queue{name.Input, name.Output} {
+		if q.Env != profile.Environment {
+			return fmt.Errorf("queue %s is in the %s environment, but profile %s is for the %s environment; select the matching profile (-i for int) or use --force", q, q.Env, profileName, profile.Environment)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"kitten", "sitting", 3},
+		{"order.mail", "order.mai", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestClosestMatch(t *testing.T) {
+	candidates := []string{
+		"CQI.prod.nevec.order::CQO.prod.nevec.order.mail",
+		"CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin",
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"CQI.prod.nevec.order::CQO.prod.nevec.order.mai", candidates[0]},
+		{"CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlyn", candidates[1]},
+		// 18 edits are within a third of the 55 characters, 19 of 56 are not.
+		{"CQI.prod.nevec.order::CQO.prod.nevec." + strings.Repeat("x", 18), candidates[0]},
+		{"CQI.prod.nevec.order::CQO.prod.nevec." + strings.Repeat("x", 19), ""},
+		{"CQI.int.foo::CQO.int.bar", ""},
+	}
+	for _, tt := range tests {
+		if got := closestMatch(tt.name, candidates); got != tt.want {
+			t.Errorf("closestMatch(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"pigeon-tool/pigeon"
+)
+
+// verifySkipped polls the hosts of skipped until none of its messages is
+// listed as an old message of subscription any more, at most retries times
+// with wait before each poll. It returns the messages still listed, or not
+// confirmed because their host did not answer.
+func verifySkipped(ctx context.Context, client *pigeon.Client, subscription string, skipped []skipTarget, wait time.Duration, retries int) []skipTarget {
+	remaining := skipped
+	for attempt := 1; attempt <= retries && len(remaining) != 0; attempt++ {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return remaining
+		}
+
+		var hosts []string
+		seen := make(map[string]bool)
+		for _, t := range remaining {
+			if !seen[t.Host] {
+				seen[t.Host] = true
+				hosts = append(hosts, t.Host)
+			}
+		}
+
+		results := client.StatusAll(ctx, hosts)
+		answered := make(map[string]bool)
+		for _, result := range results {
+			if result.Err == nil {
+				answered[result.Host] = true
+			}
+		}
+		// Both maps are keyed by the host name queried, as the targets are,
+		// whatever name the host reports for itself.
+		listed := make(map[skipTarget]bool)
+		for _, t := range oldMessageTargets(results, subscription) {
+			listed[t] = true
+		}
+
+		var still []skipTarget
+		for _, t := range remaining {
+			if listed[t] || !answered[t.Host] {
+				still = append(still, t)
+			}
+		}
+		remaining = still
+	}
+	return remaining
+}
+
+// printVerifyReport prints the outcome of verifySkipped and returns an error
+// if any message is still listed.
+func printVerifyReport(w io.Writer, skipped []skipTarget, remaining []skipTarget) error {
+	if len(remaining) == 0 {
+		fmt.Fprintf(w, "verified: %d skipped messages are no longer listed\n", len(skipped))
+		return nil
+	}
+
+	fmt.Fprintf(w, "%d of %d skipped messages are still listed:\n", len(remaining), len(skipped))
+	for _, t := range remaining {
+		fmt.Fprintf(w, "  %s %s\n", t.Host, t.ID)
+	}
+	return fmt.Errorf("%d skipped messages are still listed", len(remaining))
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"pigeon-tool/pigeon"
+)
+
+func TestOldMessageTargetsUsesQueriedHost(t *testing.T) {
+	const subscription = "CQI.prod.a.b::CQO.prod.a.b.c"
+	results := []pigeon.HostStatus{
+		{
+			Host: "127.0.0.1",
+			Status: &pigeon.Outmost{
+				Host: "localhost",
+				PigeonStatus: pigeon.OutSub{Sub: []pigeon.Subscriptions{
+					{SubscriptionName: subscription, OldMessageCount: 2, OldMessages: []string{"id0", "id1"}},
+					{SubscriptionName: "CQI.prod.x::CQO.prod.y", OldMessageCount: 1, OldMessages: []string{"other"}},
+				}},
+			},
+		},
+		{Host: "127.0.0.2", Err: errors.New("connection refused")},
+	}
+
+	want := []skipTarget{{Host: "127.0.0.1", ID: "id0"}, {Host: "127.0.0.1", ID: "id1"}}
+	if got := oldMessageTargets(results, subscription); !reflect.DeepEqual(got, want) {
+		t.Errorf("oldMessageTargets() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"pigeon-tool/pigeon"
+)
+
+// ANSI escape sequences used by the watch view.
+const (
+	ansiClear  = "\033[H\033[2J"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+	ansiReset  = "\033[0m"
+)
+
+func rowKey(host string, subscription string) string {
+	return host + " " + subscription
+}
+
+// printWatchView draws rows as a table, marking the change of each
+// subscription since the previous poll. previous is nil on the first poll.
+func printWatchView(w io.Writer, rows []queueRow, previous map[string]int, color bool) {
+	paint := func(code string, text string) string {
+		if !color {
+			return text
+		}
+		return code + text + ansiReset
+	}
+
+	current := make(map[string]bool)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tNAMESPACE\tSUBSCRIPTION\tCOUNT\tCHANGE")
+	for _, row := range rows {
+		key := rowKey(row.Host, row.Subscription)
+		current[key] = true
+
+		change := ""
+		if previous != nil {
+			before, seen := previous[key]
+			switch {
+			case !seen:
+				change = paint(ansiYellow, "new")
+			case row.Count > before:
+				change = paint(ansiRed, fmt.Sprintf("+%d", row.Count-before))
+			case row.Count < before:
+				change = paint(ansiGreen, fmt.Sprintf("-%d", before-row.Count))
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", row.Host, row.Namespace, row.Subscription, row.Count, change)
+	}
+
+	// Subscriptions that no longer have old messages.
+	var cleared []string
+	for key := range previous {
+		if !current[key] {
+			cleared = append(cleared, key)
+		}
+	}
+	sort.Strings(cleared)
+	for _, key := range cleared {
+		parts := strings.SplitN(key, " ", 2)
+		fmt.Fprintf(tw, "%s\t\t%s\t0\t%s\n", parts[0], parts[1], paint(ansiGreen, "cleared"))
+	}
+	tw.Flush()
+}
+
+// watchList polls the status of hosts every interval and redraws the list
+// until interrupted.
+func watchList(ctx context.Context, client *pigeon.Client, hosts []string, namespace string, interval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	color := isTerminal(os.Stdout)
+	var previous map[string]int
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		results := client.StatusAll(ctx, hosts)
+		if ctx.Err() != nil {
+			return nil
+		}
+		rows := collectRows(results, namespace)
+
+		if color {
+			fmt.Print(ansiClear)
+		} else if previous != nil {
+			fmt.Println()
+		}
+		fmt.Printf("Every %s: pigeon-tool list -n %s\t%s\n\n", interval, namespace, time.Now().Format("2006-01-02 15:04:05"))
+		printWatchView(os.Stdout, rows, previous, color)
+		for _, result := range results {
+			if result.Err != nil {
+				fmt.Printf("\n%s: %s\n", result.Host, result.Err.Error())
+			}
+		}
+
+		previous = make(map[string]int)
+		for _, row := range rows {
+			previous[rowKey(row.Host, row.Subscription)] = row.Count
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
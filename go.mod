@@ -2,4 +2,8 @@ module pigeon-tool
 
 go 1.13
 
-require github.com/spf13/cobra v1.0.0
+require (
+	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.3
+	gopkg.in/yaml.v2 v2.2.8
+)
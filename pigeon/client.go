@@ -0,0 +1,148 @@
+// Package pigeon is a client for the Pigeon queue admin API.
+//
+// A Client looks up the Pigeon tail hosts with an Athenz identity
+// certificate and talks to the Pigeon API on each host with a role
+// certificate.
+package pigeon
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Default Pigeon API locations.
+const (
+	DefaultStatusURL = "/api/pigeon/v1/status"
+	DefaultSkipURL   = "/api/pigeon/v1/messages/skip/"
+	DefaultPort      = 4443
+)
+
+// Options configures a Client.
+type Options struct {
+	// KeyPath and CertPath are the Athenz identity used to get the host list.
+	KeyPath  string
+	CertPath string
+	// RoleCertPath is the role certificate used to call the Pigeon API. It
+	// shares the private key at KeyPath.
+	RoleCertPath string
+	// Discoverer finds the tail hosts. If nil, the hosts are the members of
+	// the Athenz role at HostEndpoint whose names contain "tail".
+	Discoverer   HostDiscoverer
+	HostEndpoint string
+	// RootCAs verifies the Pigeon and Athenz servers; nil means the system
+	// pool. Insecure disables server verification altogether.
+	RootCAs  *x509.CertPool
+	Insecure bool
+	// StatusURL, SkipURL and Port locate the Pigeon API on each host. Zero
+	// values fall back to the defaults.
+	StatusURL string
+	SkipURL   string
+	Port      int
+	// Logger, if not nil, receives debug messages such as every request
+	// URL. The Client logs nothing by default.
+	Logger *log.Logger
+}
+
+// Client talks to the Pigeon API of every tail host in a cluster.
+type Client struct {
+	opts       Options
+	discoverer HostDiscoverer
+	role       *http.Client
+}
+
+// NewClient loads the identity and role certificates and returns a Client.
+func NewClient(opts Options) (*Client, error) {
+	if opts.StatusURL == "" {
+		opts.StatusURL = DefaultStatusURL
+	}
+	if opts.SkipURL == "" {
+		opts.SkipURL = DefaultSkipURL
+	}
+	if opts.Port == 0 {
+		opts.Port = DefaultPort
+	}
+
+	discoverer := opts.Discoverer
+	if discoverer == nil {
+		identity, err := newHTTPClient(opts.Logger, opts.KeyPath, opts.CertPath, opts.RootCAs, opts.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		discoverer = &RoleMembers{URL: opts.HostEndpoint, Filter: "tail", HTTPClient: identity, Logger: opts.Logger}
+	}
+	role, err := newHTTPClient(opts.Logger, opts.KeyPath, opts.RoleCertPath, opts.RootCAs, opts.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{opts: opts, discoverer: discoverer, role: role}, nil
+}
+
+// ListHosts returns the Pigeon tail hosts.
+func (c *Client) ListHosts(ctx context.Context) ([]string, error) {
+	return c.discoverer.Discover(ctx)
+}
+
+// Status returns the Pigeon status of a host.
+func (c *Client) Status(ctx context.Context, host string) (*Outmost, error) {
+	body, err := doGet(ctx, c.opts.Logger, c.role, c.statusURL(host))
+	if err != nil {
+		return nil, err
+	}
+
+	var status Outmost
+	if err = json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("unmarshal fail for getting pigeon api: %s", err.Error())
+	}
+	return &status, nil
+}
+
+// HostStatus is the outcome of a status request to one host.
+type HostStatus struct {
+	Host    string
+	Status  *Outmost
+	Err     error
+	Latency time.Duration
+}
+
+// StatusAll requests the status of all hosts concurrently. It returns one
+// result per host, in the order of hosts, whether the request failed or not.
+func (c *Client) StatusAll(ctx context.Context, hosts []string) []HostStatus {
+	results := make([]HostStatus, len(hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := c.Status(ctx, host)
+			results[i] = HostStatus{Host: host, Status: status, Err: err, Latency: time.Since(start)}
+		}(i, host)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// SkipMessage skips a message of a subscription on a host.
+func (c *Client) SkipMessage(ctx context.Context, host string, subscription SubscriptionName, id string) error {
+	return doPut(ctx, c.opts.Logger, c.role, c.SkipMessageURL(host, subscription, id), nil, 200)
+}
+
+func (c *Client) statusURL(host string) string {
+	return fmt.Sprintf("https://%s:%d%s", host, c.opts.Port, c.opts.StatusURL)
+}
+
+// SkipMessageURL returns the URL SkipMessage sends its PUT request to. The
+// subscription and message ID are escaped.
+func (c *Client) SkipMessageURL(host string, subscription SubscriptionName, id string) string {
+	return fmt.Sprintf("https://%s:%d%s%s?msgId=%s", host, c.opts.Port, c.opts.SkipURL, url.PathEscape(subscription.String()), url.QueryEscape(id))
+}
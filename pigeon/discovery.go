@@ -0,0 +1,137 @@
+package pigeon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HostDiscoverer finds the Pigeon tail hosts of a cluster.
+type HostDiscoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// RoleMembers discovers hosts from an Athenz role-members URL, keeping only
+// the members that contain Filter.
+type RoleMembers struct {
+	URL        string
+	Filter     string
+	HTTPClient *http.Client
+	// Logger, if not nil, receives debug messages.
+	Logger *log.Logger
+}
+
+// Discover implements HostDiscoverer.
+func (r *RoleMembers) Discover(ctx context.Context) ([]string, error) {
+	body, err := doGet(ctx, r.Logger, r.HTTPClient, r.URL)
+	if err != nil {
+		return nil, fmt.Errorf("when getting Pigeon list %s: %s", r.URL, err.Error())
+	}
+
+	var hostList []Resultdata
+	if err = json.Unmarshal(body, &hostList); err != nil {
+		return nil, fmt.Errorf("unmarshal fail for getting host list: %s", err.Error())
+	}
+	if len(hostList) == 0 {
+		return nil, fmt.Errorf("empty host list from %s", r.URL)
+	}
+
+	var hosts []string
+	for _, host := range hostList[0].Members {
+		if strings.Contains(host, r.Filter) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// StaticHosts is a fixed list of hosts.
+type StaticHosts []string
+
+// Discover implements HostDiscoverer.
+func (s StaticHosts) Discover(ctx context.Context) ([]string, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("static host list is empty")
+	}
+	return s, nil
+}
+
+// LoadStaticHosts reads a host list file with one host per line. Blank lines
+// and lines starting with # are ignored.
+func LoadStaticHosts(path string) (StaticHosts, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hosts StaticHosts
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read host file %s: %s", path, err.Error())
+	}
+	return hosts, nil
+}
+
+// DNS discovers hosts from the SRV records of Name, or from its A/AAAA
+// records when SRV is false. The addresses of A/AAAA records are resolved
+// back to host names, which the tail host certificates are issued for.
+// Ports in SRV records are ignored; the Client always uses its configured
+// port.
+type DNS struct {
+	Name     string
+	SRV      bool
+	Resolver *net.Resolver
+}
+
+// Discover implements HostDiscoverer.
+func (d *DNS) Discover(ctx context.Context) ([]string, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	if !d.SRV {
+		addrs, err := resolver.LookupHost(ctx, d.Name)
+		if err != nil {
+			return nil, fmt.Errorf("when resolving %s: %s", d.Name, err.Error())
+		}
+		var hosts []string
+		seen := make(map[string]bool)
+		for _, addr := range addrs {
+			names, err := resolver.LookupAddr(ctx, addr)
+			if err != nil || len(names) == 0 {
+				return nil, fmt.Errorf("no host name for %s of %s, use SRV records instead", addr, d.Name)
+			}
+			host := strings.TrimSuffix(names[0], ".")
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+		return hosts, nil
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, "", "", d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("when resolving SRV %s: %s", d.Name, err.Error())
+	}
+	var hosts []string
+	for _, record := range records {
+		hosts = append(hosts, strings.TrimSuffix(record.Target, "."))
+	}
+	return hosts, nil
+}
@@ -0,0 +1,107 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StatusError is returned when a server answers with an unexpected HTTP
+// status code.
+type StatusError struct {
+	Code int
+	Body []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned code %d with message: %s", e.Code, strings.TrimSpace(string(e.Body)))
+}
+
+// logf logs to logger, if it is not nil.
+func logf(logger *log.Logger, format string, v ...interface{}) {
+	if logger != nil {
+		logger.Output(2, fmt.Sprintf(format, v...))
+	}
+}
+
+func newHTTPClient(logger *log.Logger, keyPath string, certPath string, rootCAs *x509.CertPool, insecure bool) (*http.Client, error) {
+	logf(logger, "loading key %s and cert %s", keyPath, certPath)
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			Certificates:       []tls.Certificate{pair},
+			RootCAs:            rootCAs,
+			InsecureSkipVerify: insecure,
+		},
+		Dial: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 10 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout:   3 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	return client, nil
+}
+
+func doGeneric(logger *log.Logger, client *http.Client, request *http.Request, expectedCode int) ([]byte, error) {
+	logf(logger, "issuing %s to URL: %s", request.Method, request.URL)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("transaction error: %s", err.Error())
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error when reading response body: %s", err.Error())
+	}
+
+	if response.StatusCode != expectedCode {
+		return nil, &StatusError{Code: response.StatusCode, Body: body}
+	}
+
+	return body, nil
+}
+
+func doGet(ctx context.Context, logger *log.Logger, client *http.Client, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct GET request: %s", err.Error())
+	}
+
+	return doGeneric(logger, client, request, 200)
+}
+
+func doPut(ctx context.Context, logger *log.Logger, client *http.Client, url string, payload []byte, expectedCode int) error {
+	request, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(payload))
+	if err != nil {
+		logf(logger, "failed to construct PUT request: %s", err.Error())
+		return fmt.Errorf("failed to construct PUT request: %s", err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	_, err = doGeneric(logger, client, request, expectedCode)
+	if err != nil {
+		logf(logger, "%s with error: %s", url, err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package pigeon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Queue kinds: the input queue of a subscription is a CQI, its output queue
+// a CQO.
+const (
+	InputQueue  = "CQI"
+	OutputQueue = "CQO"
+)
+
+// Queue is one side of a subscription name, e.g. CQI.prod.storeeps.set.action.
+type Queue struct {
+	// Kind is InputQueue or OutputQueue.
+	Kind string
+	// Env is the environment segment, e.g. prod or int.
+	Env string
+	// Name is the rest of the queue name, e.g. storeeps.set.action.
+	Name string
+}
+
+func (q Queue) String() string {
+	return q.Kind + "." + q.Env + "." + q.Name
+}
+
+// SubscriptionName is a subscription name of the form
+// <input queue>::<output queue>, e.g.
+// CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin.
+type SubscriptionName struct {
+	Input  Queue
+	Output Queue
+}
+
+func (s SubscriptionName) String() string {
+	return s.Input.String() + "::" + s.Output.String()
+}
+
+// ParseSubscriptionName parses and validates a subscription name.
+func ParseSubscriptionName(name string) (SubscriptionName, error) {
+	parts := strings.Split(name, "::")
+	if len(parts) != 2 {
+		return SubscriptionName{}, fmt.Errorf("invalid subscription name %q: expecting CQI.<env>.<name>::CQO.<env>.<name>", name)
+	}
+	input, err := parseQueue(parts[0], InputQueue)
+	if err != nil {
+		return SubscriptionName{}, fmt.Errorf("invalid subscription name %q: %s", name, err.Error())
+	}
+	output, err := parseQueue(parts[1], OutputQueue)
+	if err != nil {
+		return SubscriptionName{}, fmt.Errorf("invalid subscription name %q: %s", name, err.Error())
+	}
+	return SubscriptionName{Input: input, Output: output}, nil
+}
+
+// parseQueue parses one side of a subscription name, which must be of kind.
+func parseQueue(queue string, kind string) (Queue, error) {
+	segments := strings.Split(queue, ".")
+	if len(segments) < 3 || segments[0] != kind {
+		return Queue{}, fmt.Errorf("%q is not of the form %s.<env>.<name>", queue, kind)
+	}
+	for _, segment := range segments[1:] {
+		if segment == "" {
+			return Queue{}, fmt.Errorf("%q has an empty segment", queue)
+		}
+		for _, c := range segment {
+			if !validQueueChar(c) {
+				return Queue{}, fmt.Errorf("%q contains %q", queue, c)
+			}
+		}
+	}
+	return Queue{Kind: kind, Env: segments[1], Name: strings.Join(segments[2:], ".")}, nil
+}
+
+func validQueueChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
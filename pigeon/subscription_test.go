@@ -0,0 +1,57 @@
+package pigeon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSubscriptionName(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    SubscriptionName
+		wantErr string
+	}{
+		{
+			name: "CQI.prod.storeeps.set.action::CQO.prod.storeeps.set.action.search.merlin",
+			want: SubscriptionName{
+				Input:  Queue{Kind: InputQueue, Env: "prod", Name: "storeeps.set.action"},
+				Output: Queue{Kind: OutputQueue, Env: "prod", Name: "storeeps.set.action.search.merlin"},
+			},
+		},
+		{
+			name: "CQI.int.nevec_order::CQO.int.nevec-order.Mail2",
+			want: SubscriptionName{
+				Input:  Queue{Kind: InputQueue, Env: "int", Name: "nevec_order"},
+				Output: Queue{Kind: OutputQueue, Env: "int", Name: "nevec-order.Mail2"},
+			},
+		},
+		{name: "CQI.prod.storeeps.set.action", wantErr: "expecting CQI.<env>.<name>::CQO.<env>.<name>"},
+		{name: "CQI.prod.a::CQO.prod.b::CQO.prod.c", wantErr: "expecting CQI.<env>.<name>::CQO.<env>.<name>"},
+		{name: "CQO.prod.a::CQO.prod.b", wantErr: `"CQO.prod.a" is not of the form CQI.<env>.<name>`},
+		{name: "CQI.prod.a::CQI.prod.b", wantErr: `"CQI.prod.b" is not of the form CQO.<env>.<name>`},
+		{name: "CQI.prod::CQO.prod.b", wantErr: "is not of the form CQI.<env>.<name>"},
+		{name: "CQI..a::CQO.prod.b", wantErr: "empty segment"},
+		{name: "CQI.prod.a::CQO.prod.b.", wantErr: "empty segment"},
+		{name: "CQI.prod.a b::CQO.prod.b", wantErr: "contains ' '"},
+		{name: "CQI.prod.a::CQO.prod.b/c", wantErr: "contains '/'"},
+	}
+	for _, tt := range tests {
+		got, err := ParseSubscriptionName(tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseSubscriptionName(%q) returned error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSubscriptionName(%q) returned error %s", tt.name, err.Error())
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSubscriptionName(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if got.String() != tt.name {
+			t.Errorf("String() = %q, want %q", got.String(), tt.name)
+		}
+	}
+}
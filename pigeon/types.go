@@ -1,4 +1,4 @@
-package cmd
+package pigeon
 
 // Resultdata is the structure of host list api
 type Resultdata struct {
@@ -19,19 +19,8 @@ type OutSub struct {
 	Sub []Subscriptions `json:"subscriptions"`
 }
 
-//Outmost is the upper of OutSub
+// Outmost is the upper of OutSub
 type Outmost struct {
 	PigeonStatus OutSub `json:"pigeonStatus"`
 	Host         string `json:"host"`
 }
-
-// Information declared as used variable
-type Information struct {
-	pigeonHostEndpoint string
-	StatusURL          string
-	SkipURL			   string
-	cert               string
-	HostList           []Resultdata
-	StatusResult       Outmost
-	tailCount          int
-}
@@ -0,0 +1,168 @@
+// Package zts requests Athenz role certificates from ZTS.
+//
+// It implements the part of the zts-rolecert utility used by pigeon-tool: a
+// CSR for the role is signed with the principal's existing private key and
+// posted to ZTS over a TLS connection authenticated by the principal's
+// identity certificate.
+package zts
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client requests role certificates from a ZTS server.
+type Client struct {
+	// URL is the ZTS API root, e.g. https://zts.athens.yahoo.com:4443/zts/v1.
+	URL string
+	// HTTPClient must present the identity certificate of the principal.
+	HTTPClient *http.Client
+	// Logger, if not nil, receives debug messages.
+	Logger *log.Logger
+}
+
+// NewClient returns a Client authenticating to ZTS with identity and
+// verifying the server against rootCAs, or the system pool if nil.
+func NewClient(ztsURL string, identity tls.Certificate, rootCAs *x509.CertPool) *Client {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			Certificates: []tls.Certificate{identity},
+			RootCAs:      rootCAs,
+		},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+	return &Client{URL: ztsURL, HTTPClient: &http.Client{Transport: transport}}
+}
+
+// RoleCertRequest describes the role certificate to request.
+type RoleCertRequest struct {
+	// Identity is the key and certificate of the requesting principal. The
+	// role certificate reuses its private key.
+	Identity tls.Certificate
+	Domain   string
+	Role     string
+	// DNSDomain is the ZTS DNS suffix used for the SAN host name.
+	DNSDomain string
+	// Expiry is the requested lifetime; zero lets ZTS pick its default.
+	Expiry time.Duration
+}
+
+type roleCertificateRequest struct {
+	CSR        string `json:"csr"`
+	ExpiryTime int64  `json:"expiryTime,omitempty"`
+}
+
+type roleToken struct {
+	Token      string `json:"token"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// RoleCertificate requests a role certificate and returns it PEM encoded.
+func (c *Client) RoleCertificate(ctx context.Context, req RoleCertRequest) ([]byte, error) {
+	if len(req.Identity.Certificate) == 0 {
+		return nil, fmt.Errorf("no identity certificate")
+	}
+	identity, err := x509.ParseCertificate(req.Identity.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity certificate: %s", err.Error())
+	}
+	key, ok := req.Identity.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", req.Identity.PrivateKey)
+	}
+
+	csr, err := GenerateRoleCSR(key, identity.Subject.CommonName, req.Domain, req.Role, req.DNSDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(roleCertificateRequest{
+		CSR:        string(csr),
+		ExpiryTime: int64(req.Expiry / time.Minute),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/domain/%s/role/%s/token", strings.TrimSuffix(c.URL, "/"),
+		url.PathEscape(req.Domain), url.PathEscape(req.Role))
+	request, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct POST request: %s", err.Error())
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	if c.Logger != nil {
+		c.Logger.Printf("requesting role certificate %s:role.%s for %s from %s", req.Domain, req.Role, identity.Subject.CommonName, endpoint)
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("transaction error: %s", err.Error())
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error when reading response body: %s", err.Error())
+	}
+
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("ZTS returned code %d with message: %s", response.StatusCode, body)
+	}
+
+	var token roleToken
+	if err = json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("unmarshal fail for ZTS role token: %s", err.Error())
+	}
+	if block, _ := pem.Decode([]byte(token.Token)); block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("ZTS returned no role certificate")
+	}
+
+	return []byte(token.Token), nil
+}
+
+// GenerateRoleCSR returns a PEM encoded CSR for roleDomain:role.roleName
+// signed by key. principal is the Athenz name of the requester, e.g.
+// user.jdoe or sports.api, which determines the SAN host name and email.
+func GenerateRoleCSR(key crypto.Signer, principal string, roleDomain string, roleName string, dnsDomain string) ([]byte, error) {
+	dot := strings.LastIndex(principal, ".")
+	if dot <= 0 || dot == len(principal)-1 {
+		return nil, fmt.Errorf("invalid principal %q, expecting <domain>.<service>", principal)
+	}
+	domain, service := principal[:dot], principal[dot+1:]
+
+	spiffe, err := url.Parse(fmt.Sprintf("spiffe://%s/ra/%s", roleDomain, roleName))
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: fmt.Sprintf("%s:role.%s", roleDomain, roleName),
+		},
+		DNSNames:       []string{fmt.Sprintf("%s.%s.%s", service, strings.Replace(domain, ".", "-", -1), dnsDomain)},
+		EmailAddresses: []string{fmt.Sprintf("%s@%s", principal, dnsDomain)},
+		URIs:           []*url.URL{spiffe},
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %s", err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
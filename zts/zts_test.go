@@ -0,0 +1,123 @@
+package zts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdentity returns a self-signed identity certificate for principal and
+// its PEM encoding.
+func testIdentity(t *testing.T, principal string) (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: principal},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return identity, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestRoleCertificate(t *testing.T) {
+	identity, certPEM := testIdentity(t, "user.jdoe")
+
+	tests := []struct {
+		name    string
+		code    int
+		token   string
+		wantErr string
+	}{
+		{name: "ok", code: 200, token: string(certPEM)},
+		{name: "forbidden", code: 403, wantErr: "ZTS returned code 403"},
+		{name: "no certificate", code: 200, token: "", wantErr: "no role certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != "POST" || r.URL.Path != "/zts/v1/domain/nevec.pigeon.prod/role/pigeon_admin_role/token" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				var body roleCertificateRequest
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("failed to decode request: %s", err.Error())
+				}
+				checkCSR(t, body.CSR)
+
+				w.WriteHeader(tt.code)
+				json.NewEncoder(w).Encode(roleToken{Token: tt.token})
+			}))
+			defer server.Close()
+
+			client := &Client{URL: server.URL + "/zts/v1", HTTPClient: server.Client()}
+			cert, err := client.RoleCertificate(context.Background(), RoleCertRequest{
+				Identity:  identity,
+				Domain:    "nevec.pigeon.prod",
+				Role:      "pigeon_admin_role",
+				DNSDomain: "zts.yahoo.cloud",
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(cert) != tt.token {
+				t.Errorf("got certificate %q, want %q", cert, tt.token)
+			}
+		})
+	}
+}
+
+// checkCSR checks the subject and SANs of a role certificate CSR for
+// user.jdoe and nevec.pigeon.prod:role.pigeon_admin_role.
+func checkCSR(t *testing.T, csrPEM string) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Errorf("request has no CSR")
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Errorf("failed to parse CSR: %s", err.Error())
+		return
+	}
+	if err = csr.CheckSignature(); err != nil {
+		t.Errorf("bad CSR signature: %s", err.Error())
+	}
+	if got, want := csr.Subject.CommonName, "nevec.pigeon.prod:role.pigeon_admin_role"; got != want {
+		t.Errorf("got CN %q, want %q", got, want)
+	}
+	if len(csr.EmailAddresses) != 1 || csr.EmailAddresses[0] != "user.jdoe@zts.yahoo.cloud" {
+		t.Errorf("got email SANs %v, want [user.jdoe@zts.yahoo.cloud]", csr.EmailAddresses)
+	}
+	if len(csr.URIs) != 1 || csr.URIs[0].String() != "spiffe://nevec.pigeon.prod/ra/pigeon_admin_role" {
+		t.Errorf("got URI SANs %v, want [spiffe://nevec.pigeon.prod/ra/pigeon_admin_role]", csr.URIs)
+	}
+	if len(csr.DNSNames) != 1 || csr.DNSNames[0] != "jdoe.user.zts.yahoo.cloud" {
+		t.Errorf("got DNS SANs %v, want [jdoe.user.zts.yahoo.cloud]", csr.DNSNames)
+	}
+}